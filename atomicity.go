@@ -0,0 +1,104 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNonAtomicFS is returned (wrapped in a *NonAtomicFSError) by
+// NewPendingFile when WithStrictAtomicity is used and the destination resides
+// on a file system on which rename(2) is not known to be atomic.
+var ErrNonAtomicFS = errors.New("file system does not guarantee atomic renames")
+
+// NonAtomicFSError describes the file system on which rename(2) is not known
+// to be atomic. It wraps ErrNonAtomicFS.
+type NonAtomicFSError struct {
+	Path   string // directory on the file system
+	FSType string // file system type, see FSCapabilities
+}
+
+func (e *NonAtomicFSError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Path, e.FSType, ErrNonAtomicFS)
+}
+
+func (e *NonAtomicFSError) Unwrap() error {
+	return ErrNonAtomicFS
+}
+
+// FSCapabilities describes the features of a file system relevant to
+// renameio, see Capabilities.
+type FSCapabilities struct {
+	// FSType is the type of the file system, e.g. "ext4" or "nfs", or its
+	// magic number in hexadecimal if unknown.
+	FSType string
+
+	// AtomicRename is false for file systems on which rename(2) is not known
+	// to be atomic: NFS, CIFS/SMB, FUSE, overlayfs and 9p.
+	AtomicRename bool
+
+	// RenameNoReplace and RenameExchange report support for the
+	// RENAME_NOREPLACE and RENAME_EXCHANGE flags of renameat2(2), used by
+	// CloseAtomicallyCreate and Exchange, respectively.
+	RenameNoReplace bool
+	RenameExchange  bool
+
+	// TmpFile reports support for O_TMPFILE, see WithAnonymousTempFile.
+	TmpFile bool
+
+	// Reflink reports support for sharing data between files using the
+	// FICLONE ioctl(2) (e.g. on Btrfs and XFS).
+	Reflink bool
+
+	// Xattr reports support for extended attributes in the user namespace,
+	// see WithXattr.
+	Xattr bool
+}
+
+// Capabilities determines the features of the file system containing path,
+// which must be a directory or a file in a writable directory. Temporary files
+// are created in the directory to probe for the features.
+//
+// File system types are only detected on Linux; elsewhere, FSType is empty,
+// AtomicRename is true and all features are reported as unsupported.
+func Capabilities(path string) (*FSCapabilities, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		path = filepath.Dir(path)
+	}
+	return probeCapabilities(path)
+}
+
+// checkAtomicity returns a *NonAtomicFSError if dir resides on a file system
+// on which rename(2) is not known to be atomic.
+func checkAtomicity(dir string) error {
+	name, atomic, err := fsType(dir)
+	if err != nil {
+		return err
+	}
+	if !atomic {
+		return &NonAtomicFSError{Path: dir, FSType: name}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsTypes maps the magic numbers reported by statfs(2) to file system types
+// and whether rename(2) is known to be atomic on them.
+var fsTypes = map[uint32]struct {
+	name   string
+	atomic bool
+}{
+	unix.BTRFS_SUPER_MAGIC:     {"btrfs", true},
+	unix.EXT4_SUPER_MAGIC:      {"ext4", true}, // also ext2 and ext3
+	unix.TMPFS_MAGIC:           {"tmpfs", true},
+	unix.XFS_SUPER_MAGIC:       {"xfs", true},
+	unix.NFS_SUPER_MAGIC:       {"nfs", false},
+	unix.SMB_SUPER_MAGIC:       {"smb", false},
+	unix.SMB2_SUPER_MAGIC:      {"smb2", false},
+	unix.CIFS_SUPER_MAGIC:      {"cifs", false},
+	unix.FUSE_SUPER_MAGIC:      {"fuse", false},
+	unix.OVERLAYFS_SUPER_MAGIC: {"overlayfs", false},
+	unix.V9FS_MAGIC:            {"9p", false},
+}
+
+// fsType returns the type of the file system containing path and whether
+// rename(2) is known to be atomic on it.
+func fsType(path string) (name string, atomic bool, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	// The width and signedness of f_type differ between architectures.
+	magic := uint32(st.Type)
+	if t, ok := fsTypes[magic]; ok {
+		return t.name, t.atomic, nil
+	}
+	return fmt.Sprintf("0x%x", magic), true, nil
+}
+
+// probeCapabilities determines the features of the file system containing
+// the directory dir by trying them on temporary files.
+func probeCapabilities(dir string) (*FSCapabilities, error) {
+	name, atomic, err := fsType(dir)
+	if err != nil {
+		return nil, err
+	}
+	caps := &FSCapabilities{FSType: name, AtomicRename: atomic}
+
+	var files [2]*os.File
+	for i := range files {
+		f, err := openTempFile(OSFS{}, nil, dir, tempPattern("capabilities"), defaultPerm)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = f.(*os.File)
+		defer os.Remove(f.Name())
+		defer f.Close()
+	}
+	a, b := files[0], files[1]
+
+	// The generic part of renameat2(2) reports existing destinations with
+	// EEXIST before the file system gets to reject the flag, so only a
+	// rename to an unused name tells whether RENAME_NOREPLACE is supported.
+	moved, err := createTemp(nil, filepath.Join(dir, tempPattern("capabilities")), func(name string) error {
+		return unix.Renameat2(unix.AT_FDCWD, a.Name(), unix.AT_FDCWD, name, unix.RENAME_NOREPLACE)
+	})
+	if err == nil {
+		caps.RenameNoReplace = true
+		if err := os.Rename(moved, a.Name()); err != nil {
+			os.Remove(moved)
+			return nil, err
+		}
+	}
+	if unix.Renameat2(unix.AT_FDCWD, a.Name(), unix.AT_FDCWD, b.Name(), unix.RENAME_EXCHANGE) == nil {
+		caps.RenameExchange = true
+		// Swap back so that the names match the open files again.
+		unix.Renameat2(unix.AT_FDCWD, a.Name(), unix.AT_FDCWD, b.Name(), unix.RENAME_EXCHANGE)
+	}
+
+	if f, ok, err := openAnonymousTempFile(dir, tempPattern("capabilities"), defaultPerm); err == nil && ok {
+		f.Close()
+		caps.TmpFile = true
+	}
+
+	caps.Reflink = unix.IoctlFileClone(int(b.Fd()), int(a.Fd())) == nil
+	caps.Xattr = setXattr(a, "user.renameio.probe", nil) == nil
+
+	return caps, nil
+}
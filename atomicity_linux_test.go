@@ -0,0 +1,83 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStrictAtomicityOverlayfs(t *testing.T) {
+	base := t.TempDir()
+	var dirs []string
+	for _, name := range []string{"lower", "upper", "work", "merged"} {
+		dir := filepath.Join(base, name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+	lower, upper, work, merged := dirs[0], dirs[1], dirs[2], dirs[3]
+
+	data := "lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work
+	if err := syscall.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		t.Skipf("cannot mount overlayfs on %s: %v", merged, err)
+	}
+	defer syscall.Unmount(merged, 0)
+
+	path := filepath.Join(merged, "foo")
+	var nonAtomic *NonAtomicFSError
+	if _, err := NewPendingFile(path, WithStrictAtomicity()); !errors.As(err, &nonAtomic) {
+		t.Errorf("NewPendingFile(%q, WithStrictAtomicity()) did not fail with NonAtomicFSError: %v", path, err)
+	} else if nonAtomic.FSType != "overlayfs" {
+		t.Errorf("NonAtomicFSError.FSType = %q, want %q", nonAtomic.FSType, "overlayfs")
+	}
+
+	if caps, err := Capabilities(merged); err != nil {
+		t.Errorf("Capabilities(%q) failed: %v", merged, err)
+	} else if caps.AtomicRename {
+		t.Errorf("Capabilities(%q).AtomicRename = true, want false", merged)
+	}
+
+	// Without the option, files can still be written.
+	if err := WriteFile(path, []byte("foo"), 0o644); err != nil {
+		t.Errorf("WriteFile(%q) failed: %v", path, err)
+	}
+}
+
+func TestCapabilitiesRenameNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo")
+	if err := ioutil.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := unix.Renameat2(unix.AT_FDCWD, path, unix.AT_FDCWD, filepath.Join(dir, "bar"), unix.RENAME_NOREPLACE) == nil
+	os.Remove(filepath.Join(dir, "bar"))
+	os.Remove(path)
+
+	if caps, err := Capabilities(dir); err != nil {
+		t.Errorf("Capabilities(%q) failed: %v", dir, err)
+	} else if caps.RenameNoReplace != want {
+		t.Errorf("Capabilities(%q).RenameNoReplace = %v, want %v", dir, caps.RenameNoReplace, want)
+	}
+	checkEntries(t, dir, 0)
+}
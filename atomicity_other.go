@@ -0,0 +1,29 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package renameio
+
+// fsType does not detect file system types on this platform and reports all
+// file systems as atomic.
+func fsType(path string) (name string, atomic bool, err error) {
+	return "", true, nil
+}
+
+// probeCapabilities does not detect any features on this platform.
+func probeCapabilities(dir string) (*FSCapabilities, error) {
+	return &FSCapabilities{AtomicRename: true}, nil
+}
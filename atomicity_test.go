@@ -0,0 +1,84 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	dir := t.TempDir()
+
+	caps, err := Capabilities(dir)
+	if err != nil {
+		t.Fatalf("Capabilities(%q) failed: %v", dir, err)
+	}
+	t.Logf("Capabilities(%q) = %+v", dir, caps)
+	if runtime.GOOS == "linux" && caps.FSType == "" {
+		t.Errorf("Capabilities(%q) did not report the file system type", dir)
+	}
+
+	// The probe files must be removed.
+	checkEntries(t, dir, 0)
+
+	// A file in the directory yields the same result.
+	path := filepath.Join(dir, "foo")
+	if err := WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Capabilities(path); err != nil {
+		t.Errorf("Capabilities(%q) failed: %v", path, err)
+	} else if *got != *caps {
+		t.Errorf("Capabilities(%q) = %+v, want %+v", path, got, caps)
+	}
+
+	if _, err := Capabilities(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Capabilities() of a missing path did not fail")
+	}
+}
+
+func TestStrictAtomicity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo")
+
+	_, atomic, err := fsType(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pf, err := NewPendingFile(path, WithStrictAtomicity())
+	if atomic {
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q, WithStrictAtomicity()) failed: %v", path, err)
+		}
+		pf.Cleanup()
+	} else {
+		var nonAtomic *NonAtomicFSError
+		if !errors.Is(err, ErrNonAtomicFS) || !errors.As(err, &nonAtomic) || nonAtomic.Path != dir {
+			t.Errorf("NewPendingFile(%q, WithStrictAtomicity()) did not fail with NonAtomicFSError for %q: %v", path, dir, err)
+		}
+	}
+
+	err = &NonAtomicFSError{Path: dir, FSType: "nfs"}
+	if !errors.Is(err, ErrNonAtomicFS) {
+		t.Errorf("NonAtomicFSError does not wrap ErrNonAtomicFS")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Default suffix for backups created in the destination directory
+const defaultBackupSuffix = "~"
+
+// backupPath returns the path at which to keep a backup of the destination
+// file as configured in cfg, or the empty string if no backup was requested.
+func backupPath(cfg *config) string {
+	if !cfg.backup {
+		return ""
+	}
+	dir, suffix := cfg.backupDir, cfg.backupSuffix
+	if dir == "" {
+		dir = filepath.Dir(cfg.path)
+	}
+	if suffix == "" && filepath.Clean(dir) == filepath.Clean(filepath.Dir(cfg.path)) {
+		suffix = defaultBackupSuffix
+	}
+	return filepath.Join(dir, filepath.Base(cfg.path)+suffix)
+}
+
+// linkReplace atomically makes newpath a hard link to oldpath, replacing
+// newpath if it exists. newpath is never missing while doing so. The name of
+// the temporary link is chosen using random, see createTemp.
+func linkReplace(fs FS, random io.Reader, oldpath, newpath string) error {
+	pattern := filepath.Join(filepath.Dir(newpath), tempPattern(filepath.Base(newpath)))
+
+	tmp, err := createTemp(random, pattern, func(name string) error {
+		return fs.Link(oldpath, name)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := fs.Rename(tmp, newpath); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// backupDestination hard-links the destination file to the backup path, if
+// one was configured and the destination exists.
+func (t *PendingFile) backupDestination() error {
+	if t.backup == "" {
+		return nil
+	}
+	if err := linkReplace(t.fs, t.random, t.path, t.backup); err != nil {
+		if os.IsNotExist(err) {
+			if _, statErr := t.fs.Lstat(t.path); os.IsNotExist(statErr) {
+				return nil // nothing to back up
+			}
+		}
+		return err
+	}
+	if t.syncDir {
+		return syncDir(t.fs, filepath.Dir(t.backup))
+	}
+	return nil
+}
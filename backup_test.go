@@ -0,0 +1,120 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	backupDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		backup  func(path string) string
+	}{
+		{
+			name:    "default suffix",
+			options: []Option{WithBackup("")},
+			backup:  func(path string) string { return path + "~" },
+		},
+		{
+			name:    "suffix",
+			options: []Option{WithBackup(".bak")},
+			backup:  func(path string) string { return path + ".bak" },
+		},
+		{
+			name:    "directory",
+			options: []Option{WithBackupDir(backupDir)},
+			backup: func(path string) string {
+				return filepath.Join(backupDir, filepath.Base(path))
+			},
+		},
+		{
+			name:    "directory and suffix",
+			options: []Option{WithBackupDir(backupDir), WithBackup(".old")},
+			backup: func(path string) string {
+				return filepath.Join(backupDir, filepath.Base(path)+".old")
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config")
+			backup := tc.backup(path)
+
+			if err := WriteFile(path, []byte("v1"), 0o644, tc.options...); err != nil {
+				t.Fatalf("WriteFile(%q) failed: %v", path, err)
+			}
+
+			if _, err := os.Lstat(backup); !os.IsNotExist(err) {
+				t.Errorf("Lstat(%q) didn't report that file doesn't exist: %v", backup, err)
+			}
+
+			for _, content := range []string{"v2", "v3"} {
+				if err := WriteFile(path, []byte(content), 0o644, tc.options...); err != nil {
+					t.Fatalf("WriteFile(%q) failed: %v", path, err)
+				}
+			}
+
+			checkContents(t, "v3", path)
+			checkContents(t, "v2", backup)
+
+			want := 2
+			if filepath.Dir(backup) != dir {
+				want = 1
+			}
+			checkEntries(t, dir, want)
+
+			os.Remove(backup)
+		})
+	}
+}
+
+func TestBackupCreate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+
+	for _, content := range []string{"v1", "v2"} {
+		if err := WriteFile(path, []byte(content), 0o644, WithBackup("")); err != nil {
+			t.Fatalf("WriteFile(%q) failed: %v", path, err)
+		}
+	}
+
+	pf, err := NewPendingFile(path, WithBackup(""), WithVersionRotation(2))
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer pf.Cleanup()
+
+	if _, err := pf.WriteString("v3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pf.CloseAtomicallyCreate(); !os.IsExist(err) {
+		t.Fatalf("CloseAtomicallyCreate() = %v, want an error wrapping os.ErrExist", err)
+	}
+
+	checkContents(t, "v2", path)
+	checkContents(t, "v1", path+"~")
+	if _, err := os.Lstat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("Lstat(%q) didn't report that file doesn't exist: %v", path+".1", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"context"
+	"os"
+)
+
+// NewPendingFileContext is like NewPendingFile, but ties the PendingFile to
+// ctx. Once ctx is cancelled, writes fail with ctx.Err() and the temporary
+// file is cleaned up automatically. A cancelled context guarantees that the
+// destination file is not replaced, even if CloseAtomicallyReplace is called
+// concurrently.
+func NewPendingFileContext(ctx context.Context, path string, opts ...Option) (*PendingFile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t, err := NewPendingFile(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	t.ctx = ctx
+	if ctx.Done() != nil {
+		t.stop = make(chan struct{})
+		go t.cleanupOnCancel(t.stop)
+	}
+
+	return t, nil
+}
+
+// cleanupOnCancel waits for either the cancellation of t.ctx, in which case
+// the temporary file is cleaned up, or for stop to be closed once t has been
+// committed or cleaned up.
+func (t *PendingFile) cleanupOnCancel(stop <-chan struct{}) {
+	select {
+	case <-t.ctx.Done():
+		t.Cleanup()
+	case <-stop:
+	}
+}
+
+// release stops the goroutine started by NewPendingFileContext, if any. The
+// caller must hold t.mu.
+func (t *PendingFile) release() {
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+}
+
+// ctxErr returns the error of the context the PendingFile is tied to, if any.
+func (t *PendingFile) ctxErr() error {
+	if t.ctx == nil {
+		return nil
+	}
+	return t.ctx.Err()
+}
+
+// WriteFileContext is like WriteFile, but aborts and leaves the destination
+// file untouched if ctx is cancelled before the file has been replaced.
+func WriteFileContext(ctx context.Context, filename string, data []byte, perm os.FileMode, opts ...Option) error {
+	t, err := NewPendingFileContext(ctx, filename, writeFileOptions(perm, opts)...)
+	if err != nil {
+		return err
+	}
+	defer t.Cleanup()
+
+	if _, err := t.Write(data); err != nil {
+		return err
+	}
+
+	return t.CloseAtomicallyReplace()
+}
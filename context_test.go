@@ -0,0 +1,184 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cancelFS cancels a context when a hard link is created, e.g. for a backup.
+type cancelFS struct {
+	OSFS
+	cancel context.CancelFunc
+}
+
+func (fs cancelFS) Link(oldname, newname string) error {
+	fs.cancel()
+	return fs.OSFS.Link(oldname, newname)
+}
+
+func TestPendingFileContext(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "target")
+
+	if err := ioutil.WriteFile(path, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pf, err := NewPendingFileContext(ctx, path)
+		if err != nil {
+			t.Fatalf("NewPendingFileContext(%q) failed: %v", path, err)
+		}
+		defer pf.Cleanup()
+
+		if _, err := pf.WriteString("committed"); err != nil {
+			t.Errorf("WriteString() failed: %v", err)
+		}
+
+		if err := pf.CloseAtomicallyReplace(); err != nil {
+			t.Errorf("CloseAtomicallyReplace() failed: %v", err)
+		}
+
+		cancel()
+
+		if got, err := ioutil.ReadFile(path); err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", path, err)
+		} else if want := "committed"; string(got) != want {
+			t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pf, err := NewPendingFileContext(ctx, path)
+		if err != nil {
+			t.Fatalf("NewPendingFileContext(%q) failed: %v", path, err)
+		}
+		defer pf.Cleanup()
+
+		if _, err := pf.WriteString("cancelled"); err != nil {
+			t.Errorf("WriteString() failed: %v", err)
+		}
+
+		cancel()
+
+		if _, err := pf.Write([]byte("more")); !errors.Is(err, context.Canceled) {
+			t.Errorf("Write() after cancel did not fail with context.Canceled: %v", err)
+		}
+
+		if err := pf.CloseAtomicallyReplace(); !errors.Is(err, context.Canceled) {
+			t.Errorf("CloseAtomicallyReplace() after cancel did not fail with context.Canceled: %v", err)
+		}
+
+		if got, err := ioutil.ReadFile(path); err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", path, err)
+		} else if want := "committed"; string(got) != want {
+			t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+		}
+	})
+
+	t.Run("cancel during backup", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pf, err := NewPendingFileContext(ctx, path, WithFS(cancelFS{cancel: cancel}), WithBackup(""))
+		if err != nil {
+			t.Fatalf("NewPendingFileContext(%q) failed: %v", path, err)
+		}
+		defer pf.Cleanup()
+
+		if _, err := pf.WriteString("cancelled"); err != nil {
+			t.Errorf("WriteString() failed: %v", err)
+		}
+
+		if err := pf.CloseAtomicallyReplace(); !errors.Is(err, context.Canceled) {
+			t.Errorf("CloseAtomicallyReplace() cancelled during backup did not fail with context.Canceled: %v", err)
+		}
+
+		if got, err := ioutil.ReadFile(path); err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", path, err)
+		} else if want := "committed"; string(got) != want {
+			t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+		}
+
+		os.Remove(path + "~")
+	})
+
+	t.Run("automatic cleanup", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pf, err := NewPendingFileContext(ctx, path)
+		if err != nil {
+			t.Fatalf("NewPendingFileContext(%q) failed: %v", path, err)
+		}
+
+		cancel()
+
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+			if _, err := os.Stat(pf.Name()); os.IsNotExist(err) {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatalf("temporary file %q not removed after cancellation: %v", pf.Name(), err)
+			}
+		}
+
+		if err := pf.Cleanup(); err != nil {
+			t.Errorf("Cleanup() failed: %v", err)
+		}
+	})
+
+	if entries, err := ioutil.ReadDir(dir); err != nil {
+		t.Errorf("ReadDir(%q) failed: %v", dir, err)
+	} else if len(entries) != 1 {
+		t.Errorf("Directory %q contains %d entries, want 1", dir, len(entries))
+	}
+}
+
+func TestWriteFileContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target")
+
+	if err := WriteFileContext(context.Background(), path, []byte("content"), 0o644); err != nil {
+		t.Errorf("WriteFileContext(%q) failed: %v", path, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := WriteFileContext(ctx, path, []byte("cancelled"), 0o644); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteFileContext(%q) did not fail with context.Canceled: %v", path, err)
+	}
+
+	if got, err := ioutil.ReadFile(path); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", path, err)
+	} else if want := "content"; string(got) != want {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+	}
+}
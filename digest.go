@@ -0,0 +1,102 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DigestMismatchError is returned when the content of a file does not match
+// the expected digest.
+type DigestMismatchError struct {
+	Path      string
+	Algorithm crypto.Hash
+	Want, Got []byte
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("%s: %v digest mismatch: got %x, want %x", e.Path, e.Algorithm, e.Got, e.Want)
+}
+
+// expectedDigest is the digest configured using WithExpectedDigest.
+type expectedDigest struct {
+	alg crypto.Hash
+	sum []byte
+}
+
+// Sum returns the running hash configured using WithHash of all data written
+// using Write, WriteString and ReadFrom so far, or nil if no hash was
+// configured. Data written by other means, e.g. WriteAt, is not included.
+func (t *PendingFile) Sum() []byte {
+	if t.hash == nil {
+		return nil
+	}
+	return t.hash.Sum(nil)
+}
+
+// verifyDigest reads back the complete temporary file and compares its digest
+// with the one configured using WithExpectedDigest, if any.
+func (t *PendingFile) verifyDigest() error {
+	if t.expected == nil {
+		return nil
+	}
+	alg := t.expected.alg
+	if !alg.Available() {
+		return fmt.Errorf("hash function %v is not available (missing import?)", alg)
+	}
+
+	fi, err := t.Stat()
+	if err != nil {
+		return err
+	}
+
+	// ReadAt does not modify the file offset.
+	h := alg.New()
+	if _, err := io.Copy(h, io.NewSectionReader(t.f, 0, fi.Size())); err != nil {
+		return err
+	}
+
+	if got := h.Sum(nil); !bytes.Equal(got, t.expected.sum) {
+		return &DigestMismatchError{
+			Path:      t.path,
+			Algorithm: alg,
+			Want:      t.expected.sum,
+			Got:       got,
+		}
+	}
+	return nil
+}
+
+// readDigest returns the digest of the file at path computed using alg.
+func readDigest(fs FS, path string, alg crypto.Hash) ([]byte, error) {
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := alg.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPendingFileSum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashed")
+
+	pf, err := NewPendingFile(path, WithHash(sha256.New()))
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer pf.Cleanup()
+
+	if _, err := pf.Write([]byte("foo")); err != nil {
+		t.Errorf("Write() failed: %v", err)
+	}
+	if _, err := pf.WriteString("bar"); err != nil {
+		t.Errorf("WriteString() failed: %v", err)
+	}
+	if _, err := pf.ReadFrom(strings.NewReader("baz")); err != nil {
+		t.Errorf("ReadFrom() failed: %v", err)
+	}
+
+	want := sha256.Sum256([]byte("foobarbaz"))
+	if got := pf.Sum(); !bytes.Equal(got, want[:]) {
+		t.Errorf("Sum() = %x, want %x", got, want)
+	}
+
+	unhashed, err := NewPendingFile(path)
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer unhashed.Cleanup()
+
+	if got := unhashed.Sum(); got != nil {
+		t.Errorf("Sum() without hash = %x, want nil", got)
+	}
+}
+
+func TestExpectedDigest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact")
+
+	if err := WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256([]byte("new"))
+
+	for _, tc := range []struct {
+		content string
+		wantErr bool
+	}{
+		{content: "corrupt", wantErr: true},
+		{content: "new"},
+	} {
+		pf, err := NewPendingFile(path, WithExpectedDigest(crypto.SHA256, want[:]))
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+		}
+
+		if _, err := pf.WriteString(tc.content); err != nil {
+			t.Errorf("WriteString() failed: %v", err)
+		}
+
+		err = pf.CloseAtomicallyReplace()
+		var mismatch *DigestMismatchError
+		if tc.wantErr {
+			if !errors.As(err, &mismatch) {
+				t.Errorf("CloseAtomicallyReplace() did not fail with DigestMismatchError: %v", err)
+			} else if got := sha256.Sum256([]byte(tc.content)); !bytes.Equal(mismatch.Got, got[:]) {
+				t.Errorf("DigestMismatchError.Got = %x, want %x", mismatch.Got, got)
+			}
+			checkContents(t, "old", path)
+		} else if err != nil {
+			t.Errorf("CloseAtomicallyReplace() failed: %v", err)
+		}
+
+		if err := pf.Cleanup(); err != nil {
+			t.Errorf("Cleanup() failed: %v", err)
+		}
+	}
+
+	checkContents(t, "new", path)
+	checkEntries(t, dir, 1)
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Default permissions for created directories
+const defaultDirPerm os.FileMode = 0o755
+
+// openTempDir creates a randomly named directory and returns its path. See
+// openTempFile.
+func openTempDir(random io.Reader, dir, pattern string, perm os.FileMode) (string, error) {
+	return createTemp(random, filepath.Join(dir, pattern), func(name string) error {
+		return os.Mkdir(name, perm)
+	})
+}
+
+// PendingDir is a pending temporary directory, waiting to replace the
+// destination directory in a call to CloseAtomicallyReplace.
+type PendingDir struct {
+	path   string
+	name   string
+	random io.Reader
+	done   bool
+}
+
+// NewPendingDir creates a temporary directory next to path, destined to
+// atomically creating or replacing the directory at path. Populate the
+// directory returned by Name, then call CloseAtomicallyReplace.
+//
+// The directory's permissions will be (0755 & ^umask). Use WithPermissions,
+// IgnoreUmask and WithStaticPermissions to control them. Other options are
+// ignored.
+func NewPendingDir(path string, opts ...Option) (*PendingDir, error) {
+	cfg := config{
+		path:       path,
+		createPerm: defaultDirPerm,
+	}
+
+	for _, o := range opts {
+		o.apply(&cfg)
+	}
+
+	if cfg.ignoreUmask && cfg.chmod == nil {
+		cfg.chmod = &cfg.createPerm
+	}
+
+	name, err := openTempDir(cfg.random, filepath.Dir(path), tempPattern(filepath.Base(path)), cfg.createPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &PendingDir{path: path, name: name, random: cfg.random}
+
+	if cfg.chmod != nil {
+		if err := os.Chmod(name, *cfg.chmod); err != nil {
+			d.Cleanup()
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// Name returns the path of the temporary directory.
+func (d *PendingDir) Name() string {
+	return d.name
+}
+
+// Cleanup is a no-op if CloseAtomicallyReplace succeeded, and otherwise
+// removes the temporary directory and its contents.
+func (d *PendingDir) Cleanup() error {
+	if d.done {
+		return nil
+	}
+	if err := os.RemoveAll(d.name); err != nil {
+		return err
+	}
+	d.done = true
+	return nil
+}
+
+// CloseAtomicallyReplace syncs all files and directories within the temporary
+// directory and atomically replaces the destination with it:
+//
+//   - If the destination does not exist, the temporary directory is renamed.
+//   - If the destination is a symlink, the temporary directory is renamed to
+//     ".<base>-<random>" and the symlink is atomically replaced by one pointing
+//     to it (see Symlink). If the previous symlink pointed to a directory
+//     named like that by an earlier PendingDir, that directory is removed.
+//   - Otherwise, the temporary directory and the destination are exchanged
+//     (see Exchange) and the previous directory is removed afterwards. This is
+//     only supported on Linux.
+//
+// Errors removing the previous directory are returned, but the destination has
+// already been replaced at that point.
+func (d *PendingDir) CloseAtomicallyReplace() error {
+	if err := syncTree(d.name); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(d.path)
+
+	fi, err := os.Lstat(d.path)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Rename(d.name, d.path); err != nil {
+			return err
+		}
+		d.done = true
+		return syncDir(OSFS{}, dir)
+
+	case err != nil:
+		return err
+
+	case fi.Mode()&os.ModeSymlink != 0:
+		old, err := os.Readlink(d.path)
+		if err != nil {
+			return err
+		}
+		// Give the tree a name IsTempName does not match, so that Sweep
+		// leaves it alone once it is live.
+		name, err := createTemp(d.random, filepath.Join(dir, committedDirPattern(filepath.Base(d.path))), func(name string) error {
+			// Reserve the name with an empty directory, which rename(2)
+			// replaces atomically. os.Rename refuses to do that.
+			if err := os.Mkdir(name, 0o700); err != nil {
+				return err
+			}
+			if err := syscall.Rename(d.name, name); err != nil {
+				os.Remove(name)
+				return &os.LinkError{Op: "rename", Old: d.name, New: name, Err: err}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		d.name = name
+		if err := Symlink(filepath.Base(d.name), d.path); err != nil {
+			return err
+		}
+		d.done = true
+		if !filepath.IsAbs(old) {
+			old = filepath.Join(dir, old)
+		}
+		if filepath.Dir(old) == filepath.Clean(dir) && isPendingDirName(filepath.Base(d.path), filepath.Base(old)) {
+			return os.RemoveAll(old)
+		}
+		return nil
+
+	default:
+		if err := renameExchange(OSFS{}, d.name, d.path); err != nil {
+			return err
+		}
+		d.done = true
+		if err := syncDir(OSFS{}, dir); err != nil {
+			return err
+		}
+		// The temporary name now refers to the previous directory.
+		return os.RemoveAll(d.name)
+	}
+}
+
+// committedDirPattern returns the pattern for the name of a directory that
+// CloseAtomicallyReplace puts behind the symlink at base.
+func committedDirPattern(base string) string {
+	return "." + base + "-*"
+}
+
+// isPendingDirName reports whether name was created by a PendingDir destined
+// for base, either as committed directory (see committedDirPattern) or as
+// temporary directory (see tempPattern).
+func isPendingDirName(base, name string) bool {
+	if rest := strings.TrimPrefix(name, "."+base+"-"); rest != name && isDigits(rest) {
+		return true
+	}
+	rest := strings.TrimPrefix(name, "."+base+tempMarker)
+	if rest == name {
+		return false
+	}
+	i := strings.IndexByte(rest, '-')
+	return i >= 0 && isDigits(rest[:i]) && isDigits(rest[i+1:])
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// syncTree calls fsync(2) on all regular files and directories within root
+// (including root itself), deepest first.
+func syncTree(root string) error {
+	var dirs []string
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			dirs = append(dirs, path)
+		case fi.Mode().IsRegular():
+			return syncDir(OSFS{}, path) // works for regular files, too
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Sync directories after their contents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := syncDir(OSFS{}, dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func populateDir(t *testing.T, d *PendingDir, content string) {
+	t.Helper()
+
+	sub := filepath.Join(d.Name(), "css")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(d.Name(), "index.html"),
+		filepath.Join(sub, "style.css"),
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPendingDir(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(t *testing.T, path string)
+	}{
+		{
+			name:  "new",
+			setup: func(t *testing.T, path string) {},
+		},
+		{
+			name: "existing directory",
+			setup: func(t *testing.T, path string) {
+				skipUnlessExchangeSupported(t, filepath.Dir(path))
+
+				if err := os.Mkdir(path, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(path, "index.html"), []byte("old"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, path string) {
+				d, err := NewPendingDir(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				populateDir(t, d, "old")
+				if err := os.Symlink(filepath.Base(d.Name()), path); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "site")
+
+			tc.setup(t, path)
+
+			d, err := NewPendingDir(path)
+			if err != nil {
+				t.Fatalf("NewPendingDir(%q) failed: %v", path, err)
+			}
+			defer d.Cleanup()
+
+			populateDir(t, d, "new")
+
+			if err := d.CloseAtomicallyReplace(); err != nil {
+				t.Fatalf("CloseAtomicallyReplace() failed: %v", err)
+			}
+
+			checkContents(t, "new",
+				filepath.Join(path, "index.html"),
+				filepath.Join(path, "css", "style.css"))
+
+			want := 1
+			if tc.name == "symlink" {
+				want = 2 // symlink and new directory
+
+				target, err := os.Readlink(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if IsTempName(target) {
+					t.Errorf("symlink target %q is a temporary name", target)
+				}
+			}
+			checkEntries(t, dir, want)
+
+			if err := d.Cleanup(); err != nil {
+				t.Errorf("Cleanup() failed: %v", err)
+			}
+			checkEntries(t, dir, want)
+		})
+	}
+}
+
+func TestPendingDirForeignSymlinkTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "site")
+
+	for _, name := range []string{".site-archive", ".site-2023-01"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(name, path); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewPendingDir(path)
+		if err != nil {
+			t.Fatalf("NewPendingDir(%q) failed: %v", path, err)
+		}
+		populateDir(t, d, "new")
+		if err := d.CloseAtomicallyReplace(); err != nil {
+			t.Fatalf("CloseAtomicallyReplace() failed: %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("previous symlink target %q was removed: %v", name, err)
+		}
+	}
+}
+
+func TestIsPendingDirName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{".site-123", true},
+		{".site.renameio-42-123", true},
+		{".site", false},
+		{".site-", false},
+		{".site-archive", false},
+		{".site-2023-01", false},
+		{".site.renameio-42-", false},
+		{".site.renameio-42", false},
+		{".sitemap-123", false},
+		{".other-123", false},
+	} {
+		if got := isPendingDirName("site", tc.name); got != tc.want {
+			t.Errorf("isPendingDirName(%q, %q) = %v, want %v", "site", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPendingDirCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "site")
+
+	d, err := NewPendingDir(path, WithStaticPermissions(0o750))
+	if err != nil {
+		t.Fatalf("NewPendingDir(%q) failed: %v", path, err)
+	}
+
+	if fi, err := os.Stat(d.Name()); err != nil {
+		t.Errorf("Stat(%q) failed: %v", d.Name(), err)
+	} else if got, want := fi.Mode()&os.ModePerm, os.FileMode(0o750); got != want {
+		t.Errorf("%q has permissions 0%o, want 0%o", d.Name(), got, want)
+	}
+
+	populateDir(t, d, "new")
+
+	if err := d.Cleanup(); err != nil {
+		t.Errorf("Cleanup() failed: %v", err)
+	}
+
+	checkEntries(t, dir, 0)
+}
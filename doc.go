@@ -18,4 +18,6 @@
 // Caveat: this package requires the file system rename(2) implementation to be
 // atomic. Notably, this is not the case when using NFS with multiple clients:
 // https://stackoverflow.com/a/41396801
+// On Linux, WithStrictAtomicity refuses to create files on such file systems,
+// and Capabilities reports the file system type and its supported features.
 package renameio
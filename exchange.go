@@ -0,0 +1,86 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// errExchangeOption is returned by CloseAtomicallyExchange for PendingFiles
+// configured with options which make no sense for an exchange.
+var errExchangeOption = errors.New("option not supported by CloseAtomicallyExchange")
+
+// Exchange atomically swaps the files or directories at oldpath and newpath,
+// i.e., a concurrent open(2) call on either path will always find one of the
+// two, never a missing file. Both paths must exist and reside on the same file
+// system. The containing directories are synced afterwards.
+//
+// Exchange uses renameat2(2) with RENAME_EXCHANGE and is only supported on
+// Linux. On other platforms, or file systems lacking support, an error is
+// returned.
+func Exchange(oldpath, newpath string) error {
+	if err := renameExchange(OSFS{}, oldpath, newpath); err != nil {
+		return err
+	}
+	olddir, newdir := filepath.Dir(oldpath), filepath.Dir(newpath)
+	if err := syncDir(OSFS{}, olddir); err != nil {
+		return err
+	}
+	if filepath.Clean(olddir) != filepath.Clean(newdir) {
+		return syncDir(OSFS{}, newdir)
+	}
+	return nil
+}
+
+// CloseAtomicallyExchange closes the temporary file and atomically exchanges
+// it with the already existing destination file (see Exchange). The previous
+// content of the destination is returned as a new PendingFile destined for the
+// same path, allowing for rollback by calling its CloseAtomicallyReplace method
+// or for discarding the previous content by calling its Cleanup method.
+//
+// As the previous content is handed back, PendingFiles created with
+// WithBackup, WithBackupDir, WithVersionRotation or WithSkipIfUnchanged are
+// not supported and cause an error, leaving the temporary file in place for
+// Cleanup to remove.
+//
+// This method is not safe for concurrent use by multiple goroutines.
+func (t *PendingFile) CloseAtomicallyExchange() (*PendingFile, error) {
+	if t.backup != "" || t.keepVersions > 0 || t.skipIfUnchanged {
+		return nil, &os.PathError{Op: "exchange", Path: t.path, Err: errExchangeOption}
+	}
+	if err := t.closeAtomically(renameExchange, false); err != nil {
+		return nil, err
+	}
+
+	// The temporary name now refers to the previous destination.
+	f, err := t.fs.OpenFile(t.tempPath(), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	osf, _ := f.(*os.File)
+	return &PendingFile{
+		File:    osf,
+		fs:      t.fs,
+		f:       f,
+		path:    t.path,
+		syncDir: t.syncDir,
+	}, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func skipUnlessExchangeSupported(t *testing.T, dir string) {
+	t.Helper()
+
+	a, b := filepath.Join(dir, ".probe-a"), filepath.Join(dir, ".probe-b")
+	for _, path := range []string{a, b} {
+		if err := ioutil.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := renameExchange(OSFS{}, a, b); err != nil {
+		t.Skipf("RENAME_EXCHANGE not supported: %v", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	dir := t.TempDir()
+	skipUnlessExchangeSupported(t, dir)
+
+	a, b := filepath.Join(dir, "a"), filepath.Join(t.TempDir(), "b")
+	if err := ioutil.WriteFile(a, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(b, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Exchange(a, b); err != nil {
+		t.Fatalf("Exchange(%q, %q) failed: %v", a, b, err)
+	}
+
+	if fi, err := os.Stat(a); err != nil {
+		t.Errorf("Stat(%q) failed: %v", a, err)
+	} else if !fi.IsDir() {
+		t.Errorf("%q is not a directory after exchange", a)
+	}
+
+	if got, err := ioutil.ReadFile(b); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", b, err)
+	} else if want := "a"; string(got) != want {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), b, want)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := Exchange(a, missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Exchange(%q, %q) did not fail with ErrNotExist: %v", a, missing, err)
+	}
+}
+
+func TestCloseAtomicallyExchange(t *testing.T) {
+	dir := t.TempDir()
+	skipUnlessExchangeSupported(t, dir)
+
+	path := filepath.Join(dir, "live")
+	if err := ioutil.WriteFile(path, []byte("blue"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pf, err := NewPendingFile(path)
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer pf.Cleanup()
+
+	if _, err := pf.WriteString("green"); err != nil {
+		t.Errorf("Write() failed: %v", err)
+	}
+
+	prev, err := pf.CloseAtomicallyExchange()
+	if err != nil {
+		t.Fatalf("CloseAtomicallyExchange() failed: %v", err)
+	}
+	defer prev.Cleanup()
+
+	if got, err := ioutil.ReadFile(path); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", path, err)
+	} else if want := "green"; string(got) != want {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+	}
+
+	if got, err := ioutil.ReadAll(prev); err != nil {
+		t.Errorf("ReadAll() failed: %v", err)
+	} else if want := "blue"; string(got) != want {
+		t.Errorf("Read unexpected previous content %q, want %q", string(got), want)
+	}
+
+	// Roll back to the previous content.
+	if err := prev.CloseAtomicallyReplace(); err != nil {
+		t.Errorf("CloseAtomicallyReplace() failed: %v", err)
+	}
+
+	if got, err := ioutil.ReadFile(path); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", path, err)
+	} else if want := "blue"; string(got) != want {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err != nil {
+		t.Errorf("ReadDir(%q) failed: %v", dir, err)
+	} else if len(entries) != 1 {
+		t.Errorf("Directory %q contains %d entries, want 1", dir, len(entries))
+	}
+}
+
+func TestCloseAtomicallyExchangeUnsupportedOption(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		option Option
+	}{
+		{"backup", WithBackup("")},
+		{"backup directory", WithBackupDir(t.TempDir())},
+		{"versions", WithVersionRotation(2)},
+		{"skip if unchanged", WithSkipIfUnchanged()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "live")
+			if err := ioutil.WriteFile(path, []byte("blue"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			pf, err := NewPendingFile(path, tc.option)
+			if err != nil {
+				t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+			}
+
+			if _, err := pf.WriteString("blue"); err != nil {
+				t.Errorf("Write() failed: %v", err)
+			}
+
+			if _, err := pf.CloseAtomicallyExchange(); !errors.Is(err, errExchangeOption) {
+				t.Errorf("CloseAtomicallyExchange() = %v, want %v", err, errExchangeOption)
+			}
+
+			if err := pf.Cleanup(); err != nil {
+				t.Errorf("Cleanup() failed: %v", err)
+			}
+
+			checkContents(t, "blue", path)
+			checkEntries(t, dir, 1)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// FS is the interface to the file system primitives used by renameio. OSFS
+// implements it using the os package. Alternative implementations may be used
+// for testing (see the renameiotest package) or to support other storage
+// backends.
+//
+// An FS may additionally implement
+//
+//	RenameNoReplace(oldpath, newpath string) error
+//	Exchange(oldpath, newpath string) error
+//
+// to support PendingFile.CloseAtomicallyCreate without falling back to Link
+// and Remove, and PendingFile.CloseAtomicallyExchange, respectively.
+// RenameNoReplace must fail with an error wrapping os.ErrExist if newpath
+// already exists.
+//
+// Features requiring an operating system file descriptor, such as
+// WithAnonymousTempFile and extended attributes, are only available with OSFS.
+type FS interface {
+	// OpenFile is the generalized open call, see os.OpenFile. Directories
+	// are opened with os.O_RDONLY in order to sync them.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Link(oldname, newname string) error
+	Symlink(oldname, newname string) error
+	Mkdir(name string, perm os.FileMode) error
+}
+
+// File is an open file of an FS. *os.File implements it.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+	Chmod(mode os.FileMode) error
+	Chown(uid, gid int) error
+}
+
+// renameNoReplacer is the optional interface implemented by an FS supporting
+// atomic renames which fail if the destination exists.
+type renameNoReplacer interface {
+	RenameNoReplace(oldpath, newpath string) error
+}
+
+// exchanger is the optional interface implemented by an FS supporting atomic
+// exchanges of two paths.
+type exchanger interface {
+	Exchange(oldpath, newpath string) error
+}
+
+// OSFS implements FS using the file system of the operating system.
+type OSFS struct{}
+
+// OpenFile calls os.OpenFile.
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// Avoid returning a non-nil interface holding a nil *os.File.
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat calls os.Stat.
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+// Lstat calls os.Lstat.
+func (OSFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+
+// Rename calls os.Rename.
+func (OSFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+// Remove calls os.Remove.
+func (OSFS) Remove(name string) error { return os.Remove(name) }
+
+// Link calls os.Link.
+func (OSFS) Link(oldname, newname string) error { return os.Link(oldname, newname) }
+
+// Symlink calls os.Symlink.
+func (OSFS) Symlink(oldname, newname string) error { return os.Symlink(oldname, newname) }
+
+// Mkdir calls os.Mkdir.
+func (OSFS) Mkdir(name string, perm os.FileMode) error { return os.Mkdir(name, perm) }
+
+// RenameNoReplace renames oldpath to newpath unless newpath already exists,
+// in which case an error wrapping os.ErrExist is returned. On Linux,
+// renameat2(2) with RENAME_NOREPLACE is used, elsewhere link(2) and unlink(2).
+func (OSFS) RenameNoReplace(oldpath, newpath string) error {
+	return osRenameNoReplace(oldpath, newpath)
+}
+
+// Exchange atomically exchanges oldpath and newpath using renameat2(2) with
+// RENAME_EXCHANGE. It is only supported on Linux.
+func (OSFS) Exchange(oldpath, newpath string) error {
+	return osRenameExchange(oldpath, newpath)
+}
+
+// isOSFS reports whether fs is the operating system's file system.
+func isOSFS(fs FS) bool {
+	_, ok := fs.(OSFS)
+	return ok
+}
+
+// renameNoReplace renames oldpath to newpath on fs unless newpath already
+// exists, in which case an error wrapping os.ErrExist is returned.
+func renameNoReplace(fs FS, oldpath, newpath string) error {
+	if r, ok := fs.(renameNoReplacer); ok {
+		return r.RenameNoReplace(oldpath, newpath)
+	}
+	return linkNoReplace(fs, oldpath, newpath)
+}
+
+// renameExchange atomically exchanges oldpath and newpath on fs.
+func renameExchange(fs FS, oldpath, newpath string) error {
+	if e, ok := fs.(exchanger); ok {
+		return e.Exchange(oldpath, newpath)
+	}
+	return &os.LinkError{Op: "exchange", Old: oldpath, New: newpath, Err: syscall.ENOTSUP}
+}
+
+// renameFS calls fs.Rename. It is passed to closeAtomically.
+func renameFS(fs FS, oldpath, newpath string) error {
+	return fs.Rename(oldpath, newpath)
+}
+
+// Name returns the name of the temporary file.
+func (t *PendingFile) Name() string { return t.f.Name() }
+
+// Stat returns the FileInfo of the temporary file.
+func (t *PendingFile) Stat() (os.FileInfo, error) { return t.f.Stat() }
+
+// Sync commits the current contents of the temporary file to stable storage.
+func (t *PendingFile) Sync() error { return t.f.Sync() }
+
+// Truncate changes the size of the temporary file.
+func (t *PendingFile) Truncate(size int64) error { return t.f.Truncate(size) }
+
+// Chmod changes the mode of the temporary file.
+func (t *PendingFile) Chmod(mode os.FileMode) error { return t.f.Chmod(mode) }
+
+// Chown changes the numeric uid and gid of the temporary file.
+func (t *PendingFile) Chown(uid, gid int) error { return t.f.Chown(uid, gid) }
+
+// Read reads up to len(b) bytes from the temporary file.
+func (t *PendingFile) Read(b []byte) (int, error) { return t.f.Read(b) }
+
+// ReadAt reads len(b) bytes from the temporary file starting at byte offset
+// off.
+func (t *PendingFile) ReadAt(b []byte, off int64) (int, error) { return t.f.ReadAt(b, off) }
+
+// WriteAt writes len(b) bytes to the temporary file starting at byte offset
+// off. Data written using WriteAt is not included in the running hash
+// configured using WithHash.
+func (t *PendingFile) WriteAt(b []byte, off int64) (int, error) { return t.f.WriteAt(b, off) }
+
+// Seek sets the offset for the next Read or Write on the temporary file.
+func (t *PendingFile) Seek(offset int64, whence int) (int64, error) { return t.f.Seek(offset, whence) }
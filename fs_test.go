@@ -0,0 +1,144 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// recordingFS wraps OSFS, recording the operations performed on it. It does
+// not implement the optional interfaces of OSFS and its files are not
+// *os.File, so the generic code paths are used.
+type recordingFS struct {
+	FS
+
+	mu  sync.Mutex
+	ops []string
+}
+
+type recordingFile struct{ File }
+
+func (fs *recordingFS) record(op string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.ops = append(fs.ops, op)
+}
+
+func (fs *recordingFS) count(op string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n := 0
+	for _, o := range fs.ops {
+		if o == op {
+			n++
+		}
+	}
+	return n
+}
+
+func (fs *recordingFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.record("open")
+	f, err := fs.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return recordingFile{f}, nil
+}
+
+func (fs *recordingFS) Rename(oldpath, newpath string) error {
+	fs.record("rename")
+	return fs.FS.Rename(oldpath, newpath)
+}
+
+func (fs *recordingFS) Link(oldname, newname string) error {
+	fs.record("link")
+	return fs.FS.Link(oldname, newname)
+}
+
+func (fs *recordingFS) Symlink(oldname, newname string) error {
+	fs.record("symlink")
+	return fs.FS.Symlink(oldname, newname)
+}
+
+func TestWithFS(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.txt")
+	fs := &recordingFS{FS: OSFS{}}
+
+	pf, err := NewPendingFile(path, WithFS(fs))
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer pf.Cleanup()
+	if pf.File != nil {
+		t.Errorf("PendingFile.File = %v, want nil for a non-OS file", pf.File)
+	}
+	if _, err := pf.WriteString("hello"); err != nil {
+		t.Fatalf("WriteString() failed: %v", err)
+	}
+	if err := pf.CloseAtomicallyCreate(); err != nil {
+		t.Fatalf("CloseAtomicallyCreate() failed: %v", err)
+	}
+	checkContents(t, "hello", path)
+	if got := fs.count("link"); got != 1 {
+		t.Errorf("CloseAtomicallyCreate() called Link %d times, want 1", got)
+	}
+
+	if err := WriteFile(path, []byte("world"), 0o644, WithFS(fs)); err != nil {
+		t.Fatalf("WriteFile(%q) failed: %v", path, err)
+	}
+	checkContents(t, "world", path)
+	if got := fs.count("rename"); got == 0 {
+		t.Errorf("WriteFile() did not call Rename")
+	}
+
+	link := filepath.Join(dir, "link")
+	for _, target := range []string{"a", "b"} {
+		if err := Symlink(target, link, WithFS(fs)); err != nil {
+			t.Fatalf("Symlink(%q, %q) failed: %v", target, link, err)
+		}
+	}
+	if got, err := os.Readlink(link); err != nil || got != "b" {
+		t.Errorf("Readlink(%q) = %q, %v, want %q", link, got, err, "b")
+	}
+	if got := fs.count("symlink"); got != 3 {
+		t.Errorf("Symlink() called FS.Symlink %d times, want 3", got)
+	}
+
+	opens := fs.count("open")
+	TempDir(dir, WithFS(fs))
+	if fs.count("open") == opens {
+		t.Errorf("TempDir() did not open files on the FS")
+	}
+
+	checkEntries(t, dir, 2)
+}
+
+func TestWithFSXattr(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foo.txt")
+
+	_, err := NewPendingFile(path, WithFS(&recordingFS{FS: OSFS{}}), WithXattr("user.foo", []byte("bar")))
+	if !errors.Is(err, syscall.ENOTSUP) {
+		t.Errorf("NewPendingFile(%q) with WithXattr did not fail with ENOTSUP: %v", path, err)
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/google/renameio/v2
 
 go 1.13
+
+require golang.org/x/sys v0.1.0
@@ -17,7 +17,12 @@
 
 package renameio
 
-import "os"
+import (
+	"crypto"
+	"hash"
+	"io"
+	"os"
+)
 
 // Option is the interface implemented by all configuration function return
 // values.
@@ -40,6 +45,52 @@ func WithTempDir(dir string) Option {
 	})
 }
 
+// WithTempPattern configures the name of the temporary file. The last "*" in
+// pattern is replaced by a random number; if pattern contains no "*", the
+// random number is appended. pattern must not contain a path separator.
+//
+// By default, the name is ".<base>.renameio-<pid>-*", where base is the base
+// name of the destination path and pid the ID of the current process. Such
+// names are recognized by IsTempName and Sweep, those configured using
+// WithTempPattern are not.
+func WithTempPattern(pattern string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.tempPattern = pattern
+	})
+}
+
+// WithRandomSource configures the source of the random numbers used in the
+// names of temporary files. It defaults to crypto/rand.Reader, so that
+// processes creating temporary files in the same directory at the same time
+// do not repeatedly choose the same names. Deterministic sources are useful in
+// tests.
+func WithRandomSource(r io.Reader) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.random = r
+	})
+}
+
+// WithTempDirCache configures NewPendingFile to choose the directory for the
+// temporary file using c instead of probing the file system each time, unless
+// a directory is configured using WithTempDir.
+func WithTempDirCache(c *TempDirCache) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.tempDirCache = c
+	})
+}
+
+// WithStrictAtomicity configures NewPendingFile to fail with a
+// *NonAtomicFSError (wrapping ErrNonAtomicFS) if the destination directory
+// resides on a file system on which rename(2) is not known to be atomic, such
+// as NFS, CIFS/SMB, FUSE, overlayfs and 9p. See Capabilities for the detection,
+// which is only available on Linux. The check is skipped for file systems
+// configured using WithFS.
+func WithStrictAtomicity() Option {
+	return optionFunc(func(cfg *config) {
+		cfg.strictAtomicity = true
+	})
+}
+
 // WithPermissions sets the permissions for the target file while respecting
 // the umask(2). Bits set in the umask are removed from the permissions given
 // unless IgnoreUmask is used.
@@ -86,3 +137,189 @@ func WithReplaceOnClose() Option {
 		c.renameOnClose = true
 	})
 }
+
+// WithSyncDir causes CloseAtomicallyReplace to fsync(2) the directory
+// containing the destination file after the rename, so that the rename itself
+// is durable. If the temporary file was created in a different directory (see
+// WithTempDir), that directory is synced as well.
+func WithSyncDir() Option {
+	return optionFunc(func(c *config) {
+		c.syncDir = true
+	})
+}
+
+// WithAnonymousTempFile causes the temporary file to be created using
+// O_TMPFILE on Linux, so that it has no name and vanishes automatically if the
+// process dies before committing it. It is only given a name right before
+// being moved to the destination. On other platforms and on file systems
+// without O_TMPFILE support a regular temporary file is used.
+//
+// Note that Name() on the PendingFile does not refer to an existing file
+// while it is anonymous.
+func WithAnonymousTempFile() Option {
+	return optionFunc(func(c *config) {
+		c.anonymous = true
+	})
+}
+
+// WithUpdateRetries sets how many times Update retries after detecting a
+// concurrent modification of the file. The default is 3.
+func WithUpdateRetries(n int) Option {
+	return optionFunc(func(c *config) {
+		c.updateRetries = n
+	})
+}
+
+// WithExistingOwnership configures the file creation to try to use the owner
+// and group of an already existing target file. If the target file doesn't
+// exist yet or is not a regular file, the file is owned by the current user
+// unless overridden using WithOwner.
+//
+// Changing the owner of a file usually requires privileges (CAP_CHOWN on
+// Linux). If the process lacks them and the ownership differs, NewPendingFile
+// fails with an error satisfying errors.Is(err, os.ErrPermission).
+func WithExistingOwnership() Option {
+	return optionFunc(func(c *config) {
+		c.attemptOwnerCopy = true
+	})
+}
+
+// WithExistingMetadata is a shorthand for WithExistingPermissions,
+// WithExistingOwnership and WithExistingXattrs.
+func WithExistingMetadata() Option {
+	return optionFunc(func(c *config) {
+		c.attemptPermCopy = true
+		c.attemptOwnerCopy = true
+		c.attemptXattrCopy = true
+	})
+}
+
+// WithOwner sets the numeric user and group ID owning the target file. See
+// WithExistingOwnership for the privileges required.
+func WithOwner(uid, gid int) Option {
+	return optionFunc(func(c *config) {
+		c.owner = &fileOwner{uid: uid, gid: gid}
+	})
+}
+
+// WithExistingXattrs configures the file creation to copy the extended
+// attributes of an already existing regular target file, such as SELinux labels
+// (security.selinux), POSIX ACLs (system.posix_acl_access) and user.*
+// attributes. Attributes the process is not permitted to set (e.g. those in the
+// trusted.* namespace without CAP_SYS_ADMIN) or which are not supported by the
+// file system are skipped.
+//
+// Extended attributes are only supported on Linux; on other platforms this
+// option has no effect.
+func WithExistingXattrs() Option {
+	return optionFunc(func(c *config) {
+		c.attemptXattrCopy = true
+	})
+}
+
+// WithXattr sets the extended attribute name to value on the target file,
+// overriding a value copied using WithExistingXattrs. Unlike for copied
+// attributes, failing to set it is an error.
+func WithXattr(name string, value []byte) Option {
+	return optionFunc(func(c *config) {
+		c.xattrs = append(c.xattrs, xattr{name: name, value: value})
+	})
+}
+
+// WithBackup causes the file previously located at the destination path, if
+// any, to be kept as a backup with suffix appended to its name (e.g. "~" or
+// ".bak") when the PendingFile is committed. The backup is created as a hard
+// link before the destination is replaced, so there is no point in time at
+// which either of them is missing. An existing backup is replaced atomically.
+func WithBackup(suffix string) Option {
+	return optionFunc(func(c *config) {
+		c.backup = true
+		c.backupSuffix = suffix
+	})
+}
+
+// WithBackupDir is like WithBackup, but keeps the backup in dir, which must
+// reside on the same file system as the destination. Unless a suffix is
+// configured using WithBackup, the backup has the same name as the
+// destination file.
+func WithBackupDir(dir string) Option {
+	return optionFunc(func(c *config) {
+		c.backup = true
+		c.backupDir = dir
+	})
+}
+
+// WithVersionRotation keeps up to keep previous versions of the destination
+// file when the PendingFile is committed, similar to logrotate: the file
+// previously located at the destination path becomes "<path>.1", the former
+// "<path>.1" becomes "<path>.2" and so on, dropping the oldest version. The
+// most recent version is created as a hard link before the destination is
+// replaced. See also ListVersions and Restore.
+func WithVersionRotation(keep int) Option {
+	return optionFunc(func(c *config) {
+		c.keepVersions = keep
+	})
+}
+
+// WithCompressedVersions causes versions kept by WithVersionRotation to be
+// compressed using gzip and named "<path>.<n>.gz".
+func WithCompressedVersions() Option {
+	return optionFunc(func(c *config) {
+		c.compressVersions = true
+	})
+}
+
+// WithSkipIfUnchanged causes committing the PendingFile to be skipped if the
+// destination file already has the same content, leaving it (and its
+// modification time) untouched and removing the temporary file instead. Only
+// the content is compared, not permissions or other metadata. Use
+// PendingFile.Unchanged to find out whether the commit was skipped.
+func WithSkipIfUnchanged() Option {
+	return optionFunc(func(c *config) {
+		c.skipIfUnchanged = true
+	})
+}
+
+// WithHash configures a running hash of all data written to the PendingFile
+// using Write, WriteString and ReadFrom, available from PendingFile.Sum. Using
+// ReadFrom with a running hash disables its in-kernel copy fast paths.
+func WithHash(h hash.Hash) Option {
+	return optionFunc(func(c *config) {
+		c.hash = h
+	})
+}
+
+// WithExpectedDigest causes the temporary file to be read back after syncing
+// it and its digest computed using alg to be compared with sum before
+// committing. On mismatch, the destination is left untouched and a
+// *DigestMismatchError is returned. The hash function must be linked into the
+// binary, e.g. by importing crypto/sha256.
+func WithExpectedDigest(alg crypto.Hash, sum []byte) Option {
+	return optionFunc(func(c *config) {
+		c.expected = &expectedDigest{alg: alg, sum: sum}
+	})
+}
+
+// WithChecksumSidecar causes a sidecar file "<path>.sha256" to be written
+// atomically when the PendingFile is committed, containing the SHA-256 digest
+// of the file in the format used by sha256sum(1). The sidecar is only replaced
+// once the new content is durably in place, so it never refers to content
+// which is not (yet) at the destination. If the commit is skipped due to
+// WithSkipIfUnchanged, a missing or stale sidecar is still written. See
+// VerifyFile.
+func WithChecksumSidecar() Option {
+	return optionFunc(func(c *config) {
+		c.sidecar = true
+	})
+}
+
+// WithFS configures the file system on which the temporary and destination
+// files are created. It defaults to OSFS. Passing nil selects OSFS, too.
+func WithFS(fs FS) Option {
+	if fs == nil {
+		fs = OSFS{}
+	}
+	return optionFunc(func(c *config) {
+		c.fs = fs
+	})
+}
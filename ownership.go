@@ -0,0 +1,50 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileOwner is a numeric user and group ID.
+type fileOwner struct {
+	uid, gid int
+}
+
+// ownerOf returns the owner of the file described by fi.
+func ownerOf(fi os.FileInfo) (fileOwner, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileOwner{}, false
+	}
+	return fileOwner{uid: int(st.Uid), gid: int(st.Gid)}, true
+}
+
+// chown changes the owner of the temporary file, described by fi, to owner
+// unless it already matches.
+func (t *PendingFile) chown(fi os.FileInfo, owner fileOwner) error {
+	if current, ok := ownerOf(fi); ok && current == owner {
+		return nil
+	}
+	if err := t.Chown(owner.uid, owner.gid); err != nil {
+		return fmt.Errorf("cannot change owner to %d:%d (insufficient privileges?): %w", owner.uid, owner.gid, err)
+	}
+	return nil
+}
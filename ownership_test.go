@@ -0,0 +1,105 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func statOwner(t *testing.T, path string) fileOwner {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, ok := ownerOf(fi)
+	if !ok {
+		t.Skip("file ownership not supported")
+	}
+	return owner
+}
+
+func TestExistingOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership requires root")
+	}
+
+	want := fileOwner{uid: 1234, gid: 5678}
+
+	for _, opt := range []Option{WithExistingOwnership(), WithExistingMetadata()} {
+		path := filepath.Join(t.TempDir(), "owned")
+		if err := ioutil.WriteFile(path, []byte("old"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(path, want.uid, want.gid); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := WriteFile(path, []byte("new"), 0o600, opt); err != nil {
+			t.Errorf("WriteFile(%q) failed: %v", path, err)
+		}
+
+		if got := statOwner(t, path); got != want {
+			t.Errorf("%q is owned by %+v, want %+v", path, got, want)
+		}
+	}
+}
+
+func TestWithOwner(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "owned")
+
+	self := fileOwner{uid: os.Geteuid(), gid: os.Getegid()}
+
+	// Setting the current owner never requires privileges.
+	if err := WriteFile(path, nil, 0o600, WithOwner(self.uid, self.gid)); err != nil {
+		t.Errorf("WriteFile(%q) failed: %v", path, err)
+	}
+
+	other := fileOwner{uid: self.uid + 1, gid: self.gid + 1}
+
+	pf, err := NewPendingFile(path, WithOwner(other.uid, other.gid))
+	if os.Geteuid() != 0 {
+		if !errors.Is(err, os.ErrPermission) {
+			t.Errorf("NewPendingFile(%q) did not fail with ErrPermission: %v", path, err)
+		}
+		if entries, err := ioutil.ReadDir(dir); err != nil {
+			t.Errorf("ReadDir(%q) failed: %v", dir, err)
+		} else if len(entries) != 1 {
+			t.Errorf("Directory %q contains %d entries, want 1", dir, len(entries))
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer pf.Cleanup()
+
+	if err := pf.CloseAtomicallyReplace(); err != nil {
+		t.Errorf("CloseAtomicallyReplace() failed: %v", err)
+	}
+
+	if got := statOwner(t, path); got != other {
+		t.Errorf("%q is owned by %+v, want %+v", path, got, other)
+	}
+}
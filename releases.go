@@ -0,0 +1,223 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Names used by Releases within its root directory
+const (
+	releasesDir     = "releases"
+	currentRelease  = "current"
+	previousRelease = "previous"
+)
+
+// ErrNoRelease is returned (wrapped) by Releases.Rollback when there is no
+// previous release to roll back to.
+var ErrNoRelease = errors.New("no such release")
+
+// Releases manages versioned release directories in the following layout,
+// where symlinks are replaced atomically using Symlink:
+//
+//	root/releases/<id>/             one directory per release
+//	root/current -> releases/<id>   the active release
+//	root/previous -> releases/<id>  the release active before, for Rollback
+//
+// Release IDs are ordered lexically when deciding which releases to prune, so
+// they should sort by age, e.g. timestamps like "20060102T150405".
+//
+// Releases is not safe for concurrent use by multiple goroutines or processes.
+type Releases struct {
+	root string
+	keep int
+}
+
+// NewReleases returns a Releases managing the release directories in root.
+// Activate prunes all but the newest keep releases; keep <= 0 disables pruning.
+func NewReleases(root string, keep int) *Releases {
+	return &Releases{root: root, keep: keep}
+}
+
+// Path returns the path of the release directory for id.
+func (r *Releases) Path(id string) string {
+	return filepath.Join(r.root, releasesDir, id)
+}
+
+// Create creates the empty release directory for id and returns its path. It
+// fails if the release already exists.
+func (r *Releases) Create(id string) (string, error) {
+	if err := validReleaseID(id); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(r.root, releasesDir), defaultDirPerm); err != nil {
+		return "", err
+	}
+	path := r.Path(id)
+	if err := os.Mkdir(path, defaultDirPerm); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// List returns the IDs of all releases in lexical order.
+func (r *Releases) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(r.root, releasesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, fi := range entries {
+		if fi.IsDir() && validReleaseID(fi.Name()) == nil {
+			ids = append(ids, fi.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Current returns the ID of the active release, or the empty string if no
+// release has been activated yet.
+func (r *Releases) Current() (string, error) {
+	return r.readLink(currentRelease)
+}
+
+// Previous returns the ID of the release which was active before the current
+// one, or the empty string if there is none.
+func (r *Releases) Previous() (string, error) {
+	return r.readLink(previousRelease)
+}
+
+// readLink returns the release ID the symlink name points to.
+func (r *Releases) readLink(name string) (string, error) {
+	target, err := os.Readlink(filepath.Join(r.root, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// Activate atomically points the current symlink at release id, remembering
+// the previously active release for Rollback, and prunes old releases.
+func (r *Releases) Activate(id string) error {
+	if err := validReleaseID(id); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(r.Path(id)); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("release %q is not a directory", id)
+	}
+
+	old, err := r.Current()
+	if err != nil {
+		return err
+	}
+
+	if err := Symlink(filepath.Join(releasesDir, id), filepath.Join(r.root, currentRelease)); err != nil {
+		return err
+	}
+
+	if old != "" && old != id {
+		if err := Symlink(filepath.Join(releasesDir, old), filepath.Join(r.root, previousRelease)); err != nil {
+			return err
+		}
+	}
+
+	return r.Prune()
+}
+
+// Rollback activates the previous release, which in turn becomes the previous
+// release for another Rollback.
+func (r *Releases) Rollback() error {
+	prev, err := r.Previous()
+	if err != nil {
+		return err
+	}
+	if prev == "" {
+		return fmt.Errorf("rollback: %w", ErrNoRelease)
+	}
+	return r.Activate(prev)
+}
+
+// Prune removes all but the newest releases as configured in NewReleases. The
+// current and previous releases are never removed. A release is renamed to a
+// hidden name before removing its contents, so that partially removed releases
+// never show up in List.
+func (r *Releases) Prune() error {
+	if r.keep <= 0 {
+		return nil
+	}
+
+	ids, err := r.List()
+	if err != nil {
+		return err
+	}
+	if len(ids) <= r.keep {
+		return nil
+	}
+
+	for _, id := range ids[:len(ids)-r.keep] {
+		// Re-read the symlinks for every release to never remove an active
+		// release, even if the symlinks changed in the meantime.
+		current, err := r.Current()
+		if err != nil {
+			return err
+		}
+		prev, err := r.Previous()
+		if err != nil {
+			return err
+		}
+		if id == current || id == prev {
+			continue
+		}
+
+		trash, err := openTempDir(nil, filepath.Join(r.root, releasesDir), tempPattern(id), 0o700)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(r.Path(id), filepath.Join(trash, id)); err != nil {
+			os.Remove(trash)
+			return err
+		}
+		if err := os.RemoveAll(trash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validReleaseID returns an error if id cannot be used as a release ID.
+func validReleaseID(id string) error {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsRune(id, filepath.Separator) {
+		return fmt.Errorf("invalid release ID %q", id)
+	}
+	return nil
+}
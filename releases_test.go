@@ -0,0 +1,114 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func checkRelease(t *testing.T, r *Releases, wantCurrent, wantPrevious string) {
+	t.Helper()
+
+	if got, err := r.Current(); err != nil {
+		t.Errorf("Current() failed: %v", err)
+	} else if got != wantCurrent {
+		t.Errorf("Current() = %q, want %q", got, wantCurrent)
+	}
+
+	if got, err := r.Previous(); err != nil {
+		t.Errorf("Previous() failed: %v", err)
+	} else if got != wantPrevious {
+		t.Errorf("Previous() = %q, want %q", got, wantPrevious)
+	}
+
+	if wantCurrent != "" {
+		checkContents(t, wantCurrent, filepath.Join(r.root, "current", "VERSION"))
+	}
+}
+
+func TestReleases(t *testing.T) {
+	r := NewReleases(t.TempDir(), 2)
+
+	if err := r.Rollback(); !errors.Is(err, ErrNoRelease) {
+		t.Errorf("Rollback() without releases did not fail with ErrNoRelease: %v", err)
+	}
+
+	checkRelease(t, r, "", "")
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		path, err := r.Create(id)
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", id, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, "VERSION"), []byte(id), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Activate(id); err != nil {
+			t.Fatalf("Activate(%q) failed: %v", id, err)
+		}
+	}
+
+	checkRelease(t, r, "4", "3")
+
+	if err := r.Rollback(); err != nil {
+		t.Errorf("Rollback() failed: %v", err)
+	}
+	checkRelease(t, r, "3", "4")
+
+	// Staging a new release prunes the oldest one, but neither the current
+	// nor the previous release.
+	if _, err := r.Create("5"); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := r.Prune(); err != nil {
+		t.Errorf("Prune() failed: %v", err)
+	}
+
+	if got, err := r.List(); err != nil {
+		t.Errorf("List() failed: %v", err)
+	} else if want := []string{"3", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %q, want %q", got, want)
+	}
+
+	checkEntries(t, filepath.Join(r.root, "releases"), 3)
+}
+
+func TestReleasesInvalid(t *testing.T) {
+	r := NewReleases(t.TempDir(), 0)
+
+	for _, id := range []string{"", ".hidden", "a/b"} {
+		if _, err := r.Create(id); err == nil {
+			t.Errorf("Create(%q) succeeded unexpectedly", id)
+		}
+	}
+
+	if _, err := r.Create("1"); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if _, err := r.Create("1"); err == nil {
+		t.Errorf("Create() of existing release succeeded unexpectedly")
+	}
+
+	if err := r.Activate("missing"); err == nil {
+		t.Errorf("Activate() of missing release succeeded unexpectedly")
+	}
+}
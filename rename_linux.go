@@ -0,0 +1,45 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// osRenameNoReplace renames oldpath to newpath unless newpath already exists, in
+// which case an error wrapping os.ErrExist is returned. It uses renameat2(2)
+// with RENAME_NOREPLACE, falling back to link(2) and unlink(2) on kernels or
+// file systems not supporting it.
+func osRenameNoReplace(oldpath, newpath string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, oldpath, unix.AT_FDCWD, newpath, unix.RENAME_NOREPLACE)
+	if err == unix.EINVAL || err == unix.ENOSYS {
+		return linkNoReplace(OSFS{}, oldpath, newpath)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "renameat2", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+// osRenameExchange atomically exchanges oldpath and newpath using renameat2(2)
+// with RENAME_EXCHANGE. Both paths must exist.
+func osRenameExchange(oldpath, newpath string) error {
+	if err := unix.Renameat2(unix.AT_FDCWD, oldpath, unix.AT_FDCWD, newpath, unix.RENAME_EXCHANGE); err != nil {
+		return &os.LinkError{Op: "renameat2", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
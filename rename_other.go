@@ -0,0 +1,35 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package renameio
+
+import (
+	"os"
+	"syscall"
+)
+
+// osRenameNoReplace renames oldpath to newpath unless newpath already exists, in
+// which case an error wrapping os.ErrExist is returned.
+func osRenameNoReplace(oldpath, newpath string) error {
+	return linkNoReplace(OSFS{}, oldpath, newpath)
+}
+
+// osRenameExchange is not supported on this platform and always fails with
+// ENOTSUP.
+func osRenameExchange(oldpath, newpath string) error {
+	return &os.LinkError{Op: "exchange", Old: oldpath, New: newpath, Err: syscall.ENOTSUP}
+}
@@ -0,0 +1,336 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameiotest
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/google/renameio/v2"
+)
+
+// Operations in which a FaultFS can inject faults. The names follow the
+// system calls used by OSFS.
+const (
+	OpOpen     = "open"
+	OpStat     = "stat"
+	OpLstat    = "lstat"
+	OpRename   = "rename"
+	OpRemove   = "remove"
+	OpLink     = "link"
+	OpSymlink  = "symlink"
+	OpMkdir    = "mkdir"
+	OpRead     = "read"
+	OpWrite    = "write"
+	OpSeek     = "seek"
+	OpFstat    = "fstat"
+	OpSync     = "fsync"
+	OpTruncate = "ftruncate"
+	OpChmod    = "fchmod"
+	OpChown    = "fchown"
+	OpClose    = "close"
+)
+
+// FaultFS wraps a renameio.FS, failing selected operations with an injected
+// error, e.g. syscall.ENOSPC, syscall.EIO, syscall.EXDEV or syscall.EACCES.
+// Operations which are not failed are passed to the wrapped FS.
+//
+// Injected errors are wrapped in an *os.PathError or *os.LinkError just like
+// the errors returned by the os package. A FaultFS is safe for concurrent use
+// by multiple goroutines.
+type FaultFS struct {
+	fs renameio.FS
+
+	mu     sync.Mutex
+	faults []fault
+}
+
+// fault is an error injected into a FaultFS.
+type fault struct {
+	op      string
+	pattern string
+	err     error
+	count   int // remaining number of failures, or -1 for unlimited
+}
+
+var _ renameio.FS = (*FaultFS)(nil)
+
+// NewFaultFS returns a FaultFS wrapping fs, which defaults to renameio.OSFS if
+// nil.
+func NewFaultFS(fs renameio.FS) *FaultFS {
+	if fs == nil {
+		fs = renameio.OSFS{}
+	}
+	return &FaultFS{fs: fs}
+}
+
+// Inject makes all following op operations on paths matching pattern fail
+// with err. The pattern syntax is that of filepath.Match; the empty pattern
+// matches all paths. Renames and links fail if either path matches. File
+// operations match the name the file was opened with.
+func (fs *FaultFS) Inject(op, pattern string, err error) {
+	fs.InjectN(op, pattern, err, -1)
+}
+
+// InjectN is like Inject, but only fails the next n matching operations.
+func (fs *FaultFS) InjectN(op, pattern string, err error, n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.faults = append(fs.faults, fault{op: op, pattern: pattern, err: err, count: n})
+}
+
+// Reset removes all injected faults.
+func (fs *FaultFS) Reset() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.faults = nil
+}
+
+// fault returns the error to inject into op on any of paths, if any.
+func (fs *FaultFS) fault(op string, paths ...string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for i := range fs.faults {
+		f := &fs.faults[i]
+		if f.op != op || f.count == 0 || !f.matches(paths) {
+			continue
+		}
+		if f.count > 0 {
+			f.count--
+		}
+		return f.err
+	}
+	return nil
+}
+
+func (f *fault) matches(paths []string) bool {
+	if f.pattern == "" {
+		return true
+	}
+	for _, p := range paths {
+		if ok, _ := filepath.Match(f.pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *FaultFS) pathError(op, name string) error {
+	if err := fs.fault(op, name); err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+func (fs *FaultFS) linkError(op, oldname, newname string) error {
+	if err := fs.fault(op, oldname, newname); err != nil {
+		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// OpenFile implements renameio.FS.
+func (fs *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (renameio.File, error) {
+	if err := fs.pathError(OpOpen, name); err != nil {
+		return nil, err
+	}
+	f, err := fs.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: f, fs: fs}, nil
+}
+
+// Stat implements renameio.FS.
+func (fs *FaultFS) Stat(name string) (os.FileInfo, error) {
+	if err := fs.pathError(OpStat, name); err != nil {
+		return nil, err
+	}
+	return fs.fs.Stat(name)
+}
+
+// Lstat implements renameio.FS.
+func (fs *FaultFS) Lstat(name string) (os.FileInfo, error) {
+	if err := fs.pathError(OpLstat, name); err != nil {
+		return nil, err
+	}
+	return fs.fs.Lstat(name)
+}
+
+// Rename implements renameio.FS.
+func (fs *FaultFS) Rename(oldpath, newpath string) error {
+	if err := fs.linkError(OpRename, oldpath, newpath); err != nil {
+		return err
+	}
+	return fs.fs.Rename(oldpath, newpath)
+}
+
+// RenameNoReplace renames oldpath to newpath unless newpath exists. Faults
+// injected into OpRename apply. If the wrapped FS does not support it, Link
+// and Remove are used instead.
+func (fs *FaultFS) RenameNoReplace(oldpath, newpath string) error {
+	r, ok := fs.fs.(interface {
+		RenameNoReplace(oldpath, newpath string) error
+	})
+	if !ok {
+		if err := fs.Link(oldpath, newpath); err != nil {
+			return err
+		}
+		return fs.Remove(oldpath)
+	}
+	if err := fs.linkError(OpRename, oldpath, newpath); err != nil {
+		return err
+	}
+	return r.RenameNoReplace(oldpath, newpath)
+}
+
+// Exchange atomically exchanges oldpath and newpath if the wrapped FS
+// supports it. Faults injected into OpRename apply.
+func (fs *FaultFS) Exchange(oldpath, newpath string) error {
+	if err := fs.linkError(OpRename, oldpath, newpath); err != nil {
+		return err
+	}
+	e, ok := fs.fs.(interface {
+		Exchange(oldpath, newpath string) error
+	})
+	if !ok {
+		return &os.LinkError{Op: OpRename, Old: oldpath, New: newpath, Err: syscall.ENOTSUP}
+	}
+	return e.Exchange(oldpath, newpath)
+}
+
+// Remove implements renameio.FS.
+func (fs *FaultFS) Remove(name string) error {
+	if err := fs.pathError(OpRemove, name); err != nil {
+		return err
+	}
+	return fs.fs.Remove(name)
+}
+
+// Link implements renameio.FS.
+func (fs *FaultFS) Link(oldname, newname string) error {
+	if err := fs.linkError(OpLink, oldname, newname); err != nil {
+		return err
+	}
+	return fs.fs.Link(oldname, newname)
+}
+
+// Symlink implements renameio.FS. Only newname is matched against the
+// patterns of injected faults.
+func (fs *FaultFS) Symlink(oldname, newname string) error {
+	if err := fs.fault(OpSymlink, newname); err != nil {
+		return &os.LinkError{Op: OpSymlink, Old: oldname, New: newname, Err: err}
+	}
+	return fs.fs.Symlink(oldname, newname)
+}
+
+// Mkdir implements renameio.FS.
+func (fs *FaultFS) Mkdir(name string, perm os.FileMode) error {
+	if err := fs.pathError(OpMkdir, name); err != nil {
+		return err
+	}
+	return fs.fs.Mkdir(name, perm)
+}
+
+// faultFile is an open file of a FaultFS.
+type faultFile struct {
+	renameio.File
+	fs *FaultFS
+}
+
+func (f *faultFile) Read(b []byte) (int, error) {
+	if err := f.fs.pathError(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Read(b)
+}
+
+func (f *faultFile) ReadAt(b []byte, off int64) (int, error) {
+	if err := f.fs.pathError(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(b, off)
+}
+
+func (f *faultFile) Write(b []byte) (int, error) {
+	if err := f.fs.pathError(OpWrite, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultFile) WriteAt(b []byte, off int64) (int, error) {
+	if err := f.fs.pathError(OpWrite, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(b, off)
+}
+
+func (f *faultFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.fs.pathError(OpSeek, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *faultFile) Stat() (os.FileInfo, error) {
+	if err := f.fs.pathError(OpFstat, f.Name()); err != nil {
+		return nil, err
+	}
+	return f.File.Stat()
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.pathError(OpSync, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.fs.pathError(OpTruncate, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *faultFile) Chmod(mode os.FileMode) error {
+	if err := f.fs.pathError(OpChmod, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Chmod(mode)
+}
+
+func (f *faultFile) Chown(uid, gid int) error {
+	if err := f.fs.pathError(OpChown, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Chown(uid, gid)
+}
+
+// Close closes the wrapped file even if a fault is injected, like close(2)
+// which releases the file descriptor even when reporting an error.
+func (f *faultFile) Close() error {
+	err := f.File.Close()
+	if ferr := f.fs.pathError(OpClose, f.Name()); ferr != nil {
+		return ferr
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameiotest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/google/renameio/v2"
+)
+
+func withUmask(t *testing.T, mask int) {
+	t.Helper()
+	old := syscall.Umask(mask)
+	t.Cleanup(func() { syscall.Umask(old) })
+}
+
+// checkDir verifies that dir contains exactly the named entries.
+func checkDir(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range entries {
+		got = append(got, fi.Name())
+	}
+	if len(got) != len(want) {
+		t.Errorf("ReadDir(%q) = %q, want %q", dir, got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("ReadDir(%q) = %q, want %q", dir, got, want)
+			return
+		}
+	}
+}
+
+func TestFaultFSInjectN(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFaultFS(nil)
+	fs.InjectN(OpStat, filepath.Join(dir, "*"), syscall.EIO, 1)
+
+	var pathErr *os.PathError
+	if _, err := fs.Stat(dir); err != nil {
+		t.Errorf("Stat(%q) of a non-matching path failed: %v", dir, err)
+	}
+	if _, err := fs.Stat(filepath.Join(dir, "foo")); !errors.As(err, &pathErr) || pathErr.Err != syscall.EIO {
+		t.Errorf("Stat() did not fail with the injected EIO: %v", err)
+	}
+	if _, err := fs.Stat(filepath.Join(dir, "foo")); !os.IsNotExist(err) {
+		t.Errorf("Stat() after the injected fault did not fail with ErrNotExist: %v", err)
+	}
+
+	fs.Inject(OpMkdir, "", syscall.EACCES)
+	fs.Reset()
+	if err := fs.Mkdir(filepath.Join(dir, "foo"), 0o755); err != nil {
+		t.Errorf("Mkdir() after Reset() failed: %v", err)
+	}
+}
+
+func TestWriteFileFaultCleanup(t *testing.T) {
+	withUmask(t, 0o077)
+
+	for _, tc := range []struct {
+		op  string
+		err error
+	}{
+		{OpFstat, syscall.EIO},
+		{OpChmod, syscall.EACCES},
+		{OpWrite, syscall.ENOSPC},
+		{OpSync, syscall.EIO},
+		{OpClose, syscall.EIO},
+		{OpRename, syscall.EXDEV},
+	} {
+		t.Run(tc.op, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "foo")
+			if err := ioutil.WriteFile(path, []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// Make sure a chmod of the temporary file is needed.
+			if err := os.Chmod(path, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			fs := NewFaultFS(nil)
+			fs.Inject(tc.op, filepath.Join(dir, ".foo*"), tc.err)
+
+			err := renameio.WriteFile(path, []byte("new"), 0o644, renameio.WithFS(fs), renameio.WithTempDir(dir))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("WriteFile(%q) did not fail with %v: %v", path, tc.err, err)
+			}
+
+			if got, err := ioutil.ReadFile(path); err != nil || string(got) != "old" {
+				t.Errorf("ReadFile(%q) = %q, %v, want %q", path, got, err, "old")
+			}
+			checkDir(t, dir, "foo")
+		})
+	}
+}
+
+func TestSymlinkFaultCleanup(t *testing.T) {
+	for _, tc := range []struct {
+		op, pattern string
+		err         error
+	}{
+		{OpSymlink, filepath.Join(".link*", "tmp.symlink"), syscall.ENOSPC},
+		{OpRename, "link", syscall.EACCES},
+	} {
+		t.Run(tc.op, func(t *testing.T) {
+			dir := t.TempDir()
+			link := filepath.Join(dir, "link")
+			if err := os.Symlink("old", link); err != nil {
+				t.Fatal(err)
+			}
+
+			fs := NewFaultFS(nil)
+			fs.Inject(tc.op, filepath.Join(dir, tc.pattern), tc.err)
+
+			if err := renameio.Symlink("new", link, renameio.WithFS(fs)); !errors.Is(err, tc.err) {
+				t.Errorf("Symlink(%q) did not fail with %v: %v", link, tc.err, err)
+			}
+
+			if got, err := os.Readlink(link); err != nil || got != "old" {
+				t.Errorf("Readlink(%q) = %q, %v, want %q", link, got, err, "old")
+			}
+			checkDir(t, dir, "link")
+		})
+	}
+}
+
+func TestCrashAndFaultFS(t *testing.T) {
+	// A FaultFS can wrap a MemFS, e.g. to check durability after errors.
+	mem := NewMemFS()
+	fs := NewFaultFS(mem)
+	fs.Inject(OpSync, "/.foo*", syscall.EIO)
+
+	if err := renameio.WriteFile("/foo", []byte("new"), 0o644, renameio.WithFS(fs)); !errors.Is(err, syscall.EIO) {
+		t.Errorf("WriteFile() did not fail with EIO: %v", err)
+	}
+	mem.Crash()
+	checkMissing(t, mem, "/foo")
+}
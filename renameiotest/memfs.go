@@ -0,0 +1,771 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameiotest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/renameio/v2"
+)
+
+// ErrCrashed is returned by all operations of a MemFS after the crash point
+// configured using CrashAfter was reached, and by files opened before a Crash.
+var ErrCrashed = errors.New("renameiotest: simulated crash")
+
+// Maximum number of symlinks followed while resolving a path
+const maxSymlinks = 40
+
+// MemFS is an in-memory renameio.FS which tracks which of its state is
+// durable, i.e. would survive a crash or power failure:
+//
+//   - The content and mode of a file become durable when the file is synced.
+//   - Directory entries (i.e. created, renamed, linked and removed files)
+//     become durable when the containing directory is synced.
+//
+// Crash drops all state which is not durable. Together with CrashAfter, this
+// allows tests to assert that files are in a consistent state no matter at
+// which point a crash occurs:
+//
+//	for n := 0; ; n++ {
+//		fs := renameiotest.NewMemFS()
+//		// ... set up and Sync the old state ...
+//		fs.CrashAfter(n)
+//		err := renameio.WriteFile("/foo", []byte("new"), 0o644, renameio.WithFS(fs))
+//		fs.Crash()
+//		// ... check that /foo holds either the old or the new content ...
+//		if err == nil {
+//			break
+//		}
+//	}
+//
+// Paths are resolved relative to the root directory. Permissions are stored,
+// but not enforced. A MemFS is safe for concurrent use by multiple goroutines.
+type MemFS struct {
+	mu         sync.Mutex
+	root       *inode
+	clock      int64
+	gen        int
+	crashAfter int
+	halted     bool
+}
+
+// inode is a file, directory or symlink of a MemFS.
+type inode struct {
+	mode     os.FileMode
+	modTime  time.Time
+	uid, gid int
+	data     []byte            // regular files
+	entries  map[string]*inode // directories
+	target   string            // symlinks
+
+	// State restored by Crash
+	durableMode    os.FileMode
+	durableData    []byte
+	durableEntries map[string]*inode
+}
+
+var _ renameio.FS = (*MemFS)(nil)
+
+// NewMemFS returns an empty MemFS consisting of only the root directory.
+func NewMemFS() *MemFS {
+	fs := &MemFS{crashAfter: -1}
+	fs.root = fs.newInode(os.ModeDir | 0o755)
+	return fs
+}
+
+// CrashAfter lets the next n operations on the file system or its open files
+// succeed. All further operations fail with ErrCrashed, leaving the state as
+// it would be found after a crash at that point, until Crash is called.
+func (fs *MemFS) CrashAfter(n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.crashAfter = n
+}
+
+// Crash simulates a crash and reboot by dropping all state which is not
+// durable. Files opened before the crash can no longer be used and the crash
+// point configured using CrashAfter is reset.
+func (fs *MemFS) Crash() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.walk(func(ino *inode) {
+		ino.mode = ino.durableMode
+		ino.data = clone(ino.durableData)
+		if ino.entries != nil {
+			ino.entries = cloneEntries(ino.durableEntries)
+		}
+	})
+	fs.gen++
+	fs.crashAfter = -1
+	fs.halted = false
+}
+
+// Sync makes all state durable, like sync(2). It is not subject to CrashAfter.
+func (fs *MemFS) Sync() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.walk(func(ino *inode) { ino.sync() })
+}
+
+// MkdirAll creates a directory named path along with any necessary parents,
+// like os.MkdirAll. It is not subject to CrashAfter.
+func (fs *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir := fs.root
+	for _, elem := range strings.Split(clean(path), "/") {
+		if elem == "" {
+			continue
+		}
+		child, ok := dir.entries[elem]
+		if !ok {
+			child = fs.newInode(os.ModeDir | perm&os.ModePerm)
+			dir.entries[elem] = child
+		} else if !child.mode.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		dir = child
+	}
+	return nil
+}
+
+// ReadFile returns the current content of the named file, like
+// ioutil.ReadFile. It is not subject to CrashAfter.
+func (fs *MemFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	ino, err := fs.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if ino.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return clone(ino.data), nil
+}
+
+// OpenFile opens the named file, see os.OpenFile.
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (renameio.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return nil, err
+	}
+
+	ino, err := fs.lookup(name, true)
+	switch {
+	case err == syscall.ENOENT && flag&os.O_CREATE != 0:
+		dir, base, perr := fs.parent(name)
+		if perr != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: perr}
+		}
+		if _, ok := dir.entries[base]; ok {
+			// A dangling symlink.
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		ino = fs.newInode(perm & os.ModePerm)
+		dir.entries[base] = ino
+
+	case err != nil:
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+
+	case ino.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+
+	case flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		ino.data = nil
+		ino.modTime = fs.now()
+	}
+
+	return &memFile{fs: fs, ino: ino, name: name, flag: flag, gen: fs.gen}, nil
+}
+
+// Stat returns the FileInfo of the named file, following symlinks.
+func (fs *MemFS) Stat(name string) (os.FileInfo, error) {
+	return fs.stat("stat", name, true)
+}
+
+// Lstat returns the FileInfo of the named file without following a final
+// symlink.
+func (fs *MemFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.stat("lstat", name, false)
+}
+
+func (fs *MemFS) stat(op, name string, follow bool) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return nil, err
+	}
+	ino, err := fs.lookup(name, follow)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return ino.stat(name), nil
+}
+
+// Rename renames oldpath to newpath, replacing newpath if it exists, see
+// rename(2).
+func (fs *MemFS) Rename(oldpath, newpath string) error {
+	return fs.rename("rename", oldpath, newpath, func(*inode) error { return nil })
+}
+
+// RenameNoReplace renames oldpath to newpath unless newpath exists, see
+// renameat2(2) with RENAME_NOREPLACE.
+func (fs *MemFS) RenameNoReplace(oldpath, newpath string) error {
+	return fs.rename("renameat2", oldpath, newpath, func(existing *inode) error {
+		if existing != nil {
+			return syscall.EEXIST
+		}
+		return nil
+	})
+}
+
+// Exchange atomically exchanges oldpath and newpath, see renameat2(2) with
+// RENAME_EXCHANGE.
+func (fs *MemFS) Exchange(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return err
+	}
+	olddir, oldbase, err := fs.parent(oldpath)
+	if err != nil {
+		return &os.LinkError{Op: "renameat2", Old: oldpath, New: newpath, Err: err}
+	}
+	newdir, newbase, err := fs.parent(newpath)
+	if err != nil {
+		return &os.LinkError{Op: "renameat2", Old: oldpath, New: newpath, Err: err}
+	}
+	a, aok := olddir.entries[oldbase]
+	b, bok := newdir.entries[newbase]
+	if !aok || !bok {
+		return &os.LinkError{Op: "renameat2", Old: oldpath, New: newpath, Err: syscall.ENOENT}
+	}
+	olddir.entries[oldbase], newdir.entries[newbase] = b, a
+	return nil
+}
+
+// rename implements Rename and RenameNoReplace. check is called with the
+// inode at newpath, or nil, before replacing it.
+func (fs *MemFS) rename(op, oldpath, newpath string, check func(existing *inode) error) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return err
+	}
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: err}
+	}
+
+	olddir, oldbase, err := fs.parent(oldpath)
+	if err != nil {
+		return linkErr(err)
+	}
+	newdir, newbase, err := fs.parent(newpath)
+	if err != nil {
+		return linkErr(err)
+	}
+	ino, ok := olddir.entries[oldbase]
+	if !ok {
+		return linkErr(syscall.ENOENT)
+	}
+	existing := newdir.entries[newbase]
+	if err := check(existing); err != nil {
+		return linkErr(err)
+	}
+	if existing == ino {
+		return nil // hard links to the same file
+	}
+	if existing != nil {
+		switch {
+		case existing.mode.IsDir() && !ino.mode.IsDir():
+			return linkErr(syscall.EISDIR)
+		case !existing.mode.IsDir() && ino.mode.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case existing.mode.IsDir() && len(existing.entries) > 0:
+			return linkErr(syscall.ENOTEMPTY)
+		}
+	}
+	if ino.mode.IsDir() && strings.HasPrefix(clean(newpath)+"/", clean(oldpath)+"/") {
+		return linkErr(syscall.EINVAL)
+	}
+
+	delete(olddir.entries, oldbase)
+	newdir.entries[newbase] = ino
+	return nil
+}
+
+// Remove removes the named file or empty directory.
+func (fs *MemFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return err
+	}
+	dir, base, err := fs.parent(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	ino, ok := dir.entries[base]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOENT}
+	}
+	if ino.mode.IsDir() && len(ino.entries) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(dir.entries, base)
+	return nil
+}
+
+// Link creates newname as a hard link to oldname, which must not be a
+// directory. A final symlink in oldname is not followed.
+func (fs *MemFS) Link(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return err
+	}
+	ino, err := fs.lookup(oldname, false)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if ino.mode.IsDir() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	if err := fs.create(newname, ino); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (fs *MemFS) Symlink(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return err
+	}
+	ino := fs.newInode(os.ModeSymlink | 0o777)
+	ino.target = oldname
+	if err := fs.create(newname, ino); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Mkdir creates a new directory with the specified name and permissions.
+func (fs *MemFS) Mkdir(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.step(); err != nil {
+		return err
+	}
+	if err := fs.create(name, fs.newInode(os.ModeDir|perm&os.ModePerm)); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// step counts an operation towards the crash point configured using
+// CrashAfter. The caller must hold fs.mu.
+func (fs *MemFS) step() error {
+	if fs.halted {
+		return ErrCrashed
+	}
+	if fs.crashAfter == 0 {
+		fs.halted = true
+		return ErrCrashed
+	}
+	if fs.crashAfter > 0 {
+		fs.crashAfter--
+	}
+	return nil
+}
+
+// now returns a new, strictly increasing modification time so that each
+// modification can be told apart.
+func (fs *MemFS) now() time.Time {
+	fs.clock++
+	return time.Unix(0, fs.clock)
+}
+
+func (fs *MemFS) newInode(mode os.FileMode) *inode {
+	ino := &inode{mode: mode, modTime: fs.now(), durableMode: mode}
+	if mode.IsDir() {
+		ino.entries = map[string]*inode{}
+		ino.durableEntries = map[string]*inode{}
+	}
+	return ino
+}
+
+// create adds ino to the directory containing name unless name exists.
+func (fs *MemFS) create(name string, ino *inode) error {
+	dir, base, err := fs.parent(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := dir.entries[base]; ok {
+		return syscall.EEXIST
+	}
+	dir.entries[base] = ino
+	return nil
+}
+
+// walk calls fn for each inode reachable from the root, once.
+func (fs *MemFS) walk(fn func(*inode)) {
+	seen := map[*inode]bool{}
+	var visit func(*inode)
+	visit = func(ino *inode) {
+		if seen[ino] {
+			return
+		}
+		seen[ino] = true
+		// Crash may replace the entries, so visit the durable ones, too.
+		children := make([]*inode, 0, len(ino.entries)+len(ino.durableEntries))
+		for _, child := range ino.entries {
+			children = append(children, child)
+		}
+		for _, child := range ino.durableEntries {
+			children = append(children, child)
+		}
+		fn(ino)
+		for _, child := range children {
+			visit(child)
+		}
+	}
+	visit(fs.root)
+}
+
+// parent returns the directory containing name and the final element of
+// name.
+func (fs *MemFS) parent(name string) (*inode, string, error) {
+	dir, base := filepath.Split(clean(name))
+	if base == "" {
+		return nil, "", syscall.EINVAL // the root directory
+	}
+	ino, err := fs.lookup(dir, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ino.mode.IsDir() {
+		return nil, "", syscall.ENOTDIR
+	}
+	return ino, base, nil
+}
+
+// lookup returns the inode at name, following a final symlink if follow is
+// true. Symlinks in other elements of name are always followed.
+func (fs *MemFS) lookup(name string, follow bool) (*inode, error) {
+	for n := 0; n <= maxSymlinks; n++ {
+		elems := strings.Split(strings.TrimPrefix(clean(name), "/"), "/")
+		ino, dir := fs.root, "/"
+		resolved := true
+		for i, elem := range elems {
+			if elem == "" {
+				continue
+			}
+			if !ino.mode.IsDir() {
+				return nil, syscall.ENOTDIR
+			}
+			child, ok := ino.entries[elem]
+			if !ok {
+				return nil, syscall.ENOENT
+			}
+			last := i == len(elems)-1
+			if child.mode&os.ModeSymlink != 0 && (follow || !last) {
+				target := child.target
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				name = filepath.Join(append([]string{target}, elems[i+1:]...)...)
+				resolved = false
+				break
+			}
+			ino, dir = child, filepath.Join(dir, elem)
+		}
+		if resolved {
+			return ino, nil
+		}
+	}
+	return nil, syscall.ELOOP
+}
+
+// clean returns name as a cleaned absolute path.
+func clean(name string) string {
+	return filepath.Clean("/" + name)
+}
+
+// sync makes the state of ino durable.
+func (ino *inode) sync() {
+	ino.durableMode = ino.mode
+	ino.durableData = clone(ino.data)
+	if ino.entries != nil {
+		ino.durableEntries = cloneEntries(ino.entries)
+	}
+}
+
+func (ino *inode) stat(name string) os.FileInfo {
+	return &fileInfo{
+		name:    filepath.Base(name),
+		size:    int64(len(ino.data)),
+		mode:    ino.mode,
+		modTime: ino.modTime,
+	}
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func cloneEntries(entries map[string]*inode) map[string]*inode {
+	c := make(map[string]*inode, len(entries))
+	for name, ino := range entries {
+		c[name] = ino
+	}
+	return c
+}
+
+// fileInfo implements os.FileInfo for a MemFS.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// memFile is an open file of a MemFS.
+type memFile struct {
+	fs     *MemFS
+	ino    *inode
+	name   string
+	flag   int
+	gen    int
+	off    int64
+	closed bool
+}
+
+// check counts an operation on f and verifies that f can still be used. The
+// caller must hold f.fs.mu.
+func (f *memFile) check(op string) error {
+	if err := f.fs.step(); err != nil {
+		return err
+	}
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	if f.gen != f.fs.gen {
+		return &os.PathError{Op: op, Path: f.name, Err: ErrCrashed}
+	}
+	return nil
+}
+
+func (f *memFile) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	n, err := f.readAt(b, f.off)
+	f.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EINVAL}
+	}
+	return f.readAt(b, off)
+}
+
+func (f *memFile) readAt(b []byte, off int64) (int, error) {
+	if f.ino.mode.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	}
+	if off >= int64(len(f.ino.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.ino.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write"); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.off = int64(len(f.ino.data))
+	}
+	n, err := f.writeAt(b, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EINVAL}
+	}
+	return f.writeAt(b, off)
+}
+
+func (f *memFile) writeAt(b []byte, off int64) (int, error) {
+	if !f.writable() {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+	if end := off + int64(len(b)); end > int64(len(f.ino.data)) {
+		f.ino.data = append(f.ino.data, make([]byte, end-int64(len(f.ino.data)))...)
+	}
+	copy(f.ino.data[off:], b)
+	f.ino.modTime = f.fs.now()
+	return len(b), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.ino.data))
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("stat"); err != nil {
+		return nil, err
+	}
+	return f.ino.stat(f.name), nil
+}
+
+// Sync makes the content and mode of a file, or the entries of a directory,
+// durable.
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("sync"); err != nil {
+		return err
+	}
+	f.ino.sync()
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("truncate"); err != nil {
+		return err
+	}
+	if size < 0 || !f.writable() {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
+	if size <= int64(len(f.ino.data)) {
+		f.ino.data = f.ino.data[:size:size]
+	} else {
+		f.ino.data = append(f.ino.data, make([]byte, size-int64(len(f.ino.data)))...)
+	}
+	f.ino.modTime = f.fs.now()
+	return nil
+}
+
+func (f *memFile) Chmod(mode os.FileMode) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("chmod"); err != nil {
+		return err
+	}
+	f.ino.mode = f.ino.mode&^os.ModePerm | mode&os.ModePerm
+	return nil
+}
+
+func (f *memFile) Chown(uid, gid int) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("chown"); err != nil {
+		return err
+	}
+	f.ino.uid, f.ino.gid = uid, gid
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameiotest
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/renameio/v2"
+)
+
+func checkContent(t *testing.T, fs *MemFS, name, want string) {
+	t.Helper()
+	got, err := fs.ReadFile(name)
+	if err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", name, err)
+	} else if string(got) != want {
+		t.Errorf("ReadFile(%q) = %q, want %q", name, got, want)
+	}
+}
+
+func checkMissing(t *testing.T, fs *MemFS, name string) {
+	t.Helper()
+	if _, err := fs.ReadFile(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadFile(%q) did not fail with ErrNotExist: %v", name, err)
+	}
+}
+
+// writeFile writes a file without any syncing.
+func writeFile(t *testing.T, fs *MemFS, name, content string) {
+	t.Helper()
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func syncFile(t *testing.T, fs *MemFS, name string) {
+	t.Helper()
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFSDurability(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.MkdirAll("/dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fs.Sync()
+
+	// Neither file nor directory synced.
+	writeFile(t, fs, "/dir/a", "a")
+	fs.Crash()
+	checkMissing(t, fs, "/dir/a")
+
+	// Only the file synced.
+	writeFile(t, fs, "/dir/b", "b")
+	syncFile(t, fs, "/dir/b")
+	fs.Crash()
+	checkMissing(t, fs, "/dir/b")
+
+	// Only the directory synced: the infamous zero-length file.
+	writeFile(t, fs, "/dir/c", "c")
+	syncFile(t, fs, "/dir")
+	fs.Crash()
+	checkContent(t, fs, "/dir/c", "")
+
+	// Both synced.
+	writeFile(t, fs, "/dir/d", "d")
+	syncFile(t, fs, "/dir/d")
+	syncFile(t, fs, "/dir")
+	fs.Crash()
+	checkContent(t, fs, "/dir/d", "d")
+
+	// A rename is lost unless the directory is synced.
+	if err := fs.Rename("/dir/d", "/dir/e"); err != nil {
+		t.Fatal(err)
+	}
+	fs.Crash()
+	checkContent(t, fs, "/dir/d", "d")
+	checkMissing(t, fs, "/dir/e")
+}
+
+func TestMemFSCrashInvalidatesFiles(t *testing.T) {
+	fs := NewMemFS()
+	f, err := fs.OpenFile("/foo", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Crash()
+	if _, err := f.Write([]byte("foo")); !errors.Is(err, ErrCrashed) {
+		t.Errorf("Write() after Crash() did not fail with ErrCrashed: %v", err)
+	}
+}
+
+func TestMemFSCrashAfter(t *testing.T) {
+	fs := NewMemFS()
+	fs.CrashAfter(1)
+	if err := fs.Mkdir("/a", 0o755); err != nil {
+		t.Errorf("Mkdir() before the crash point failed: %v", err)
+	}
+	if err := fs.Mkdir("/b", 0o755); !errors.Is(err, ErrCrashed) {
+		t.Errorf("Mkdir() at the crash point did not fail with ErrCrashed: %v", err)
+	}
+	if _, err := fs.Stat("/a"); !errors.Is(err, ErrCrashed) {
+		t.Errorf("Stat() after the crash point did not fail with ErrCrashed: %v", err)
+	}
+	fs.Crash()
+	if _, err := fs.Stat("/a"); !os.IsNotExist(err) {
+		t.Errorf("Stat() after Crash() did not fail with ErrNotExist: %v", err)
+	}
+}
+
+func TestWriteFileCrashConsistency(t *testing.T) {
+	const path = "/dir/foo"
+
+	for n := 0; ; n++ {
+		fs := NewMemFS()
+		if err := fs.MkdirAll("/dir", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, fs, path, "old")
+		fs.Sync()
+
+		fs.CrashAfter(n)
+		err := renameio.WriteFile(path, []byte("new"), 0o644, renameio.WithFS(fs))
+		fs.Crash()
+
+		got, rerr := fs.ReadFile(path)
+		if rerr != nil {
+			t.Fatalf("crash after %d operations: ReadFile(%q) failed: %v", n, path, rerr)
+		}
+		if string(got) != "old" && string(got) != "new" {
+			t.Errorf("crash after %d operations: ReadFile(%q) = %q, want %q or %q", n, path, got, "old", "new")
+		}
+		if err == nil {
+			if string(got) != "new" {
+				t.Errorf("WriteFile(%q) succeeded, but the new content was lost in a crash", path)
+			}
+			break
+		}
+		if !errors.Is(err, ErrCrashed) {
+			t.Fatalf("crash after %d operations: WriteFile(%q) failed: %v", n, path, err)
+		}
+	}
+}
+
+func TestReplaceWithoutSyncDirNotDurable(t *testing.T) {
+	fs := NewMemFS()
+	writeFile(t, fs, "/foo", "old")
+	fs.Sync()
+
+	pf, err := renameio.NewPendingFile("/foo", renameio.WithFS(fs), renameio.WithTempDir("/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Cleanup()
+	if _, err := pf.WriteString("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pf.CloseAtomicallyReplace(); err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, fs, "/foo", "new")
+
+	fs.Crash()
+	checkContent(t, fs, "/foo", "old")
+}
+
+func TestMemFSSymlink(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.MkdirAll("/releases/v1", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/releases/v1/bin", "v1")
+
+	for i := 0; i < 2; i++ {
+		if err := renameio.Symlink("releases/v1", "/current", renameio.WithFS(fs)); err != nil {
+			t.Fatalf("Symlink() failed: %v", err)
+		}
+	}
+	checkContent(t, fs, "/current/bin", "v1")
+
+	if fi, err := fs.Lstat("/current"); err != nil {
+		t.Errorf("Lstat() failed: %v", err)
+	} else if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat() returned mode %v, want a symlink", fi.Mode())
+	}
+}
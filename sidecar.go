@@ -0,0 +1,125 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256" // for crypto.SHA256
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Suffix of the checksum sidecar files written by WithChecksumSidecar
+const sidecarSuffix = ".sha256"
+
+var errMalformedSidecar = errors.New("malformed checksum file")
+
+// sidecarContent returns the content of the checksum sidecar for the
+// temporary file, or nil if no sidecar was requested.
+func (t *PendingFile) sidecarContent() ([]byte, error) {
+	if !t.sidecar {
+		return nil, nil
+	}
+	sum, err := readDigest(t.fs, t.tempPath(), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return t.sidecarLine(sum), nil
+}
+
+// sidecarLine formats sum as checksum sidecar of the destination file.
+func (t *PendingFile) sidecarLine(sum []byte) []byte {
+	return []byte(fmt.Sprintf("%x  %s\n", sum, filepath.Base(t.path)))
+}
+
+// refreshSidecar writes the checksum sidecar of the destination file if it is
+// missing or does not match the destination's content, for commits skipped by
+// WithSkipIfUnchanged.
+func (t *PendingFile) refreshSidecar() error {
+	sum, err := readDigest(t.fs, t.path, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	b, _, err := readWithIdentity(t.fs, t.path+sidecarSuffix)
+	if err != nil {
+		return err
+	}
+	if want, err := parseSidecar(b); err == nil && bytes.Equal(want, sum) {
+		return nil
+	}
+	return t.writeSidecar(t.sidecarLine(sum))
+}
+
+// parseSidecar returns the SHA-256 digest in the checksum sidecar content b.
+func parseSidecar(b []byte) ([]byte, error) {
+	fields := strings.Fields(string(b))
+	if len(fields) < 1 {
+		return nil, errMalformedSidecar
+	}
+	sum, err := hex.DecodeString(fields[0])
+	if err != nil || len(sum) != crypto.SHA256.Size() {
+		return nil, errMalformedSidecar
+	}
+	return sum, nil
+}
+
+// writeSidecar atomically replaces the checksum sidecar of the destination
+// file with content, unless it is nil.
+func (t *PendingFile) writeSidecar(content []byte) error {
+	if content == nil {
+		return nil
+	}
+	return WriteFile(t.path+sidecarSuffix, content, 0o644, WithFS(t.fs))
+}
+
+// VerifyFile checks the file at path against the SHA-256 digest in its
+// "<path>.sha256" sidecar file, as written by WithChecksumSidecar or
+// sha256sum(1). A *DigestMismatchError is returned if the content does not
+// match.
+func VerifyFile(path string) error {
+	sidecar := path + sidecarSuffix
+	b, err := ioutil.ReadFile(sidecar)
+	if err != nil {
+		return err
+	}
+
+	want, err := parseSidecar(b)
+	if err != nil {
+		return fmt.Errorf("%s: %v", sidecar, err)
+	}
+
+	got, err := readDigest(OSFS{}, path, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(got, want) {
+		return &DigestMismatchError{
+			Path:      path,
+			Algorithm: crypto.SHA256,
+			Want:      want,
+			Got:       got,
+		}
+	}
+	return nil
+}
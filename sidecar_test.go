@@ -0,0 +1,111 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.tar")
+
+	if err := VerifyFile(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("VerifyFile(%q) without sidecar did not fail with ErrNotExist: %v", path, err)
+	}
+
+	for _, content := range []string{"v1", "v2"} {
+		if err := WriteFile(path, []byte(content), 0o644, WithChecksumSidecar()); err != nil {
+			t.Fatalf("WriteFile(%q) failed: %v", path, err)
+		}
+
+		want := fmt.Sprintf("%x  foo.tar\n", sha256.Sum256([]byte(content)))
+		checkContents(t, want, path+".sha256")
+
+		if err := VerifyFile(path); err != nil {
+			t.Errorf("VerifyFile(%q) failed: %v", path, err)
+		}
+	}
+
+	checkEntries(t, dir, 2)
+
+	// Modify the file behind the sidecar's back.
+	if err := ioutil.WriteFile(path, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mismatch *DigestMismatchError
+	if err := VerifyFile(path); !errors.As(err, &mismatch) {
+		t.Errorf("VerifyFile(%q) did not fail with DigestMismatchError: %v", path, err)
+	}
+
+	if err := ioutil.WriteFile(path+".sha256", []byte("not a checksum\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyFile(path); err == nil || errors.As(err, &mismatch) {
+		t.Errorf("VerifyFile(%q) with malformed sidecar returned unexpected error: %v", path, err)
+	}
+}
+
+func TestChecksumSidecarUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.tar")
+	want := fmt.Sprintf("%x  foo.tar\n", sha256.Sum256([]byte("content")))
+
+	if err := ioutil.WriteFile(path, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		sidecar string // empty for none
+	}{
+		{"missing", ""},
+		{"stale", fmt.Sprintf("%x  foo.tar\n", sha256.Sum256([]byte("old")))},
+		{"malformed", "not a checksum\n"},
+		{"current", want},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(path + ".sha256")
+			if tc.sidecar != "" {
+				if err := ioutil.WriteFile(path+".sha256", []byte(tc.sidecar), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if changed, err := WriteFileIfChanged(path, []byte("content"), 0o644, WithChecksumSidecar()); err != nil {
+				t.Fatalf("WriteFileIfChanged(%q) failed: %v", path, err)
+			} else if changed {
+				t.Errorf("WriteFileIfChanged(%q) = true, want false", path)
+			}
+
+			checkContents(t, want, path+".sha256")
+			if err := VerifyFile(path); err != nil {
+				t.Errorf("VerifyFile(%q) failed: %v", path, err)
+			}
+			checkEntries(t, dir, 2)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default minimum age of temporary files removed by Sweep
+const defaultSweepMinAge = time.Hour
+
+// Minimum number of digits of the random numbers in legacy temporary file
+// names. Random non-negative int64 values have fewer digits only rarely.
+const minLegacyDigits = 15
+
+// SweepOption is the interface implemented by all configuration function
+// return values of Sweep.
+type SweepOption interface {
+	applySweep(*sweepConfig)
+}
+
+type sweepOptionFunc func(*sweepConfig)
+
+func (fn sweepOptionFunc) applySweep(cfg *sweepConfig) {
+	fn(cfg)
+}
+
+type sweepConfig struct {
+	minAge time.Duration
+	dryRun bool
+	legacy bool
+}
+
+// WithMinAge configures Sweep to only remove temporary files which were last
+// modified at least d ago. The default is one hour.
+func WithMinAge(d time.Duration) SweepOption {
+	return sweepOptionFunc(func(cfg *sweepConfig) {
+		cfg.minAge = d
+	})
+}
+
+// WithLegacyNames configures Sweep to also remove temporary files named
+// following the scheme of earlier versions of renameio: a dot, the base name
+// of the destination and a random number of at least 15 digits. Such names
+// cannot be reliably told apart from other dotfiles, so only use this option
+// on directories which contain no such files.
+func WithLegacyNames() SweepOption {
+	return sweepOptionFunc(func(cfg *sweepConfig) {
+		cfg.legacy = true
+	})
+}
+
+// WithDryRun configures Sweep to only report the stale temporary files
+// without removing them.
+func WithDryRun() SweepOption {
+	return sweepOptionFunc(func(cfg *sweepConfig) {
+		cfg.dryRun = true
+	})
+}
+
+// Sweep removes stale temporary files left behind in dir by processes which
+// died before calling CloseAtomicallyReplace or Cleanup. It returns the paths
+// of the removed files, even if an error occurred removing others.
+//
+// A regular file is considered a stale temporary file if its name follows the
+// default naming scheme of renameio (see IsTempName) or, with WithLegacyNames,
+// the one used before, it has not been
+// modified for the duration configured using WithMinAge and no process holds
+// it open. On Linux, open files are determined using /proc/*/fd; files held
+// open by processes whose /proc entries are not accessible (e.g. those of
+// other users) cannot be detected. On other platforms, only the age is
+// considered.
+//
+// Journals written by Transaction are never removed, and neither are the
+// temporary files listed in journals stored in dir, as Recover needs them to
+// complete the transaction. Temporary files of transactions whose journal is
+// stored in another directory are not recognized, so Recover must be called
+// before sweeping their directories.
+func Sweep(dir string, opts ...SweepOption) ([]string, error) {
+	cfg := sweepConfig{minAge: defaultSweepMinAge}
+	for _, o := range opts {
+		o.applySweep(&cfg)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-cfg.minAge)
+	var stale []string
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if !IsTempName(fi.Name()) && !(cfg.legacy && isLegacyTempName(fi.Name())) {
+			continue
+		}
+		stale = append(stale, fi.Name())
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	open, err := openFiles()
+	if err != nil {
+		return nil, err
+	}
+	journaled, err := journalTemps(dir)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Paths in /proc/*/fd are absolute and free of symlinks.
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	var firstErr error
+	for _, name := range stale {
+		if open[filepath.Join(resolved, name)] {
+			continue
+		}
+		if journaled[filepath.Join(abs, name)] || journaled[filepath.Join(resolved, name)] {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if !cfg.dryRun {
+			if err := os.Remove(path); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		removed = append(removed, path)
+	}
+	return removed, firstErr
+}
+
+// isLegacyTempName reports whether name follows the naming scheme of the
+// temporary files created by earlier versions of renameio: a dot, the base name
+// of the destination and a random number of at least minLegacyDigits digits.
+func isLegacyTempName(name string) bool {
+	if !strings.HasPrefix(name, ".") || strings.HasPrefix(name, journalPrefix) {
+		return false
+	}
+	digits := len(name) - len(strings.TrimRight(name, "0123456789"))
+	return digits >= minLegacyDigits && digits < len(name)-1
+}
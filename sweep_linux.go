@@ -0,0 +1,59 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// openFiles returns the paths of all files held open by processes whose
+// /proc/<pid>/fd directory is accessible.
+func openFiles() (map[string]bool, error) {
+	pids, err := readDirNames("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	open := map[string]bool{}
+	for _, pid := range pids {
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", pid, "fd")
+		// The process may have exited or belong to another user.
+		fds, err := readDirNames(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdDir, fd)); err == nil {
+				open[target] = true
+			}
+		}
+	}
+	return open, nil
+}
+
+// readDirNames returns the names of the entries of dir.
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
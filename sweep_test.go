@@ -0,0 +1,124 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIsLegacyTempName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{".foo.txt8674665223082153551", true},
+		{".foo.txt867466522308215", true},
+		{".foo1", false},
+		{".python_history2", false},
+		{".bashrc.1", false},
+		{"foo.txt8674665223082153551", false},
+		{".foo.txt", false},
+		{".8674665223082153551", false},
+		{".renameio-txn-8674665223082153551", false},
+	} {
+		if got := isLegacyTempName(tc.name); got != tc.want {
+			t.Errorf("isLegacyTempName(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+
+	create := func(name string, mtime time.Time) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	stale := create(".foo.renameio-1-2", old)
+	legacy := create(".foo8674665223082153551", old)
+	recent := create(".foo.renameio-1-3", time.Now())
+	open := create(".bar.renameio-1-4", old)
+	var kept []string
+	for _, name := range []string{"foo", ".hidden", ".python_history2", ".config.v2", ".bashrc.1", ".foo.sha256", ".baz.renameio-1-5"} {
+		kept = append(kept, create(name, old))
+	}
+
+	// The temporary file of a transaction is needed by Recover.
+	if _, err := writeJournal(dir, &journal{Files: []journalEntry{{Temp: kept[len(kept)-1], Path: filepath.Join(dir, "baz")}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	want := []string{stale}
+	if runtime.GOOS != "linux" {
+		want = append(want, open)
+	}
+	sort.Strings(want)
+
+	sweep := func(want []string, opts ...SweepOption) {
+		t.Helper()
+		got, err := Sweep(dir, opts...)
+		if err != nil {
+			t.Fatalf("Sweep(%q) failed: %v", dir, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Sweep(%q) = %q, want %q", dir, got, want)
+		}
+	}
+
+	sweep(want, WithDryRun())
+	checkEntries(t, dir, 5+len(kept)) // including the journal
+
+	sweep(want)
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stat(%q) after Sweep() did not fail with ErrNotExist: %v", stale, err)
+	}
+
+	sweep([]string{legacy}, WithLegacyNames())
+
+	if runtime.GOOS == "linux" {
+		sweep([]string{recent}, WithMinAge(0))
+	}
+
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Stat(%q) after Sweep() failed: %v", path, err)
+		}
+	}
+}
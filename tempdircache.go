@@ -0,0 +1,131 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// TempDirCache caches the decision made by TempDir whether the temporary
+// directory (os.TempDir) can be used for files destined for a given directory,
+// avoiding the probing of the file system each time. Decisions are cached per
+// mount of the destination directory, as rename(2) fails between different
+// mounts even of the same device (e.g. bind mounts). On Linux, mounts are
+// identified using statx(2) (STATX_MNT_ID, Linux 5.8 and newer). Elsewhere, or
+// if that is not supported, decisions are cached per device (st_dev) and
+// destination directory.
+//
+// The cache is invalidated when the value of os.TempDir changes (e.g. because
+// TMPDIR was modified) and, on Linux, when the mount table of the process
+// changes (as reported by /proc/self/mountinfo).
+//
+// A TempDirCache is safe for concurrent use by multiple goroutines. The zero
+// value is ready to use.
+type TempDirCache struct {
+	mu     sync.Mutex
+	tmpdir string
+	useTmp map[tempDirKey]bool
+	mounts *mountWatcher
+}
+
+// tempDirKey identifies the mount of a destination directory in a
+// TempDirCache: either by its mount ID or, if not known, by the device and the
+// directory itself.
+type tempDirKey struct {
+	mountID uint64
+	dev     uint64
+	dir     string
+}
+
+// NewTempDirCache returns an empty TempDirCache.
+func NewTempDirCache() *TempDirCache {
+	return &TempDirCache{}
+}
+
+// TempDir is like the TempDir function, but caches its decision.
+func (c *TempDirCache) TempDir(dest string) string {
+	return c.tempDir(OSFS{}, filepath.Join(dest, "renameio-TempDir"))
+}
+
+// Close releases the file descriptor used to watch the mount table and empties
+// the cache. The cache can still be used afterwards.
+func (c *TempDirCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mounts == nil {
+		return nil
+	}
+	err := c.mounts.close()
+	c.mounts = nil
+	c.useTmp = nil
+	return err
+}
+
+// tempDir returns the directory in which to create temporary files for the
+// destination path dest on fs, see tempDir.
+func (c *TempDirCache) tempDir(fs FS, dest string) string {
+	dir := filepath.Dir(dest)
+	fi, err := fs.Stat(dir)
+	if err != nil {
+		return tempDir(fs, "", dest)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return tempDir(fs, "", dest)
+	}
+	key := tempDirKey{dev: uint64(st.Dev), dir: filepath.Clean(dir)}
+	if isOSFS(fs) {
+		if id, ok := mountID(dir); ok {
+			key = tempDirKey{mountID: id}
+		}
+	}
+
+	tmpdir := os.TempDir()
+
+	c.mu.Lock()
+	if c.useTmp == nil || c.tmpdir != tmpdir || c.mounts.changed() {
+		c.useTmp = map[tempDirKey]bool{}
+		c.tmpdir = tmpdir
+		if c.mounts == nil {
+			c.mounts = newMountWatcher()
+		}
+	}
+	useTmp, ok := c.useTmp[key]
+	c.mu.Unlock()
+
+	if ok {
+		if useTmp {
+			return tmpdir
+		}
+		return dir
+	}
+
+	chosen := tempDir(fs, "", dest)
+
+	c.mu.Lock()
+	// Only cache the decision if nothing changed in the meantime.
+	if c.tmpdir == tmpdir && c.useTmp != nil {
+		c.useTmp[key] = chosen == tmpdir
+	}
+	c.mu.Unlock()
+
+	return chosen
+}
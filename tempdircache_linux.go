@@ -0,0 +1,69 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import "golang.org/x/sys/unix"
+
+// mountWatcher detects changes of the mount table of the process.
+type mountWatcher struct {
+	fd int
+}
+
+// newMountWatcher returns a mountWatcher using /proc/self/mountinfo. If it
+// cannot be opened, changes are not detected.
+func newMountWatcher() *mountWatcher {
+	// os.Open would register the file with the runtime's poller, whose
+	// epoll_wait(2) calls would consume the change notifications.
+	fd, err := unix.Open("/proc/self/mountinfo", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	return &mountWatcher{fd: fd}
+}
+
+// changed reports whether the mount table changed since the last call or the
+// creation of w. It can be called on a nil *mountWatcher.
+func (w *mountWatcher) changed() bool {
+	if w == nil {
+		return false
+	}
+	// The kernel signals changes of the mount table using POLLPRI and
+	// POLLERR, which are reset by polling.
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLPRI}}
+	for {
+		n, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		return err != nil || n > 0 && fds[0].Revents&(unix.POLLPRI|unix.POLLERR) != 0
+	}
+}
+
+func (w *mountWatcher) close() error {
+	if w == nil {
+		return nil
+	}
+	return unix.Close(w.fd)
+}
+
+// mountID returns the ID of the mount containing path, if the kernel reports
+// it.
+func mountID(path string) (uint64, bool) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_MNT_ID, &stx); err != nil {
+		return 0, false
+	}
+	return stx.Mnt_id, stx.Mask&unix.STATX_MNT_ID != 0
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMountWatcher(t *testing.T) {
+	w := newMountWatcher()
+	if w == nil {
+		t.Skip("/proc/self/mountinfo not available")
+	}
+	defer w.close()
+
+	if w.changed() {
+		t.Errorf("changed() = true before any mount")
+	}
+
+	// A pending timer keeps a thread of the runtime waiting in epoll_wait(2),
+	// which must not consume the notifications meant for the watcher.
+	timer := time.AfterFunc(time.Hour, func() {})
+	defer timer.Stop()
+
+	mnt := t.TempDir()
+	for i := 0; i < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if err := syscall.Mount("tmpfs", mnt, "tmpfs", 0, ""); err != nil {
+			t.Skipf("cannot mount tmpfs on %s: %v", mnt, err)
+		}
+		changed := w.changed()
+		syscall.Unmount(mnt, 0)
+		if !changed {
+			t.Fatalf("changed() = false after mounting %s", mnt)
+		}
+		if !w.changed() {
+			t.Fatalf("changed() = false after unmounting %s", mnt)
+		}
+		if w.changed() {
+			t.Fatalf("changed() = true twice for a single unmount")
+		}
+	}
+}
+
+func TestTempDirCacheBindMount(t *testing.T) {
+	if tmpdir, ok := os.LookupEnv("TMPDIR"); ok {
+		defer os.Setenv("TMPDIR", tmpdir) // restore
+	} else {
+		defer os.Unsetenv("TMPDIR") // restore
+	}
+	tmpdir := t.TempDir()
+	os.Setenv("TMPDIR", tmpdir)
+
+	// dir and bind share the device of tmpdir, but rename(2) between tmpdir
+	// and bind fails with EXDEV.
+	dir, bind := t.TempDir(), t.TempDir()
+	if err := syscall.Mount(dir, bind, "", syscall.MS_BIND, ""); err != nil {
+		t.Skipf("cannot bind mount %s on %s: %v", dir, bind, err)
+	}
+	defer syscall.Unmount(bind, 0)
+	if _, ok := mountID(dir); !ok {
+		t.Skip("statx(2) does not report mount IDs")
+	}
+
+	cache := NewTempDirCache()
+	defer cache.Close()
+
+	for _, tc := range []struct {
+		dest, want string
+	}{
+		{dir, tmpdir},
+		{bind, bind},
+		{dir, tmpdir},
+		{bind, bind},
+	} {
+		if got := cache.TempDir(tc.dest); got != tc.want {
+			t.Errorf("TempDir(%q) = %q, want %q", tc.dest, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package renameio
+
+// mountWatcher is not supported on this platform; changes of the mount table
+// are not detected.
+type mountWatcher struct{}
+
+func newMountWatcher() *mountWatcher {
+	return nil
+}
+
+func (w *mountWatcher) changed() bool {
+	return false
+}
+
+func (w *mountWatcher) close() error {
+	return nil
+}
+
+// mountID is not supported on this platform.
+func mountID(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempDirCache(t *testing.T) {
+	if tmpdir, ok := os.LookupEnv("TMPDIR"); ok {
+		defer os.Setenv("TMPDIR", tmpdir) // restore
+	} else {
+		defer os.Unsetenv("TMPDIR") // restore
+	}
+	tmpdir := t.TempDir()
+	os.Setenv("TMPDIR", tmpdir)
+
+	dir := t.TempDir()
+	fs := &recordingFS{FS: OSFS{}}
+	cache := NewTempDirCache()
+	defer cache.Close()
+
+	create := func(name string) *PendingFile {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		pf, err := NewPendingFile(path, WithFS(fs), WithTempDirCache(cache))
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+		}
+		t.Cleanup(func() { pf.Cleanup() })
+		return pf
+	}
+
+	// The first call probes the file system (two files), later ones only
+	// create the temporary file.
+	for i, want := range []int{3, 1, 1} {
+		opens := fs.count("open")
+		pf := create("foo")
+		if got := fs.count("open") - opens; got != want {
+			t.Errorf("NewPendingFile() #%d opened %d files, want %d", i, got, want)
+		}
+		if got := filepath.Dir(pf.Name()); got != tmpdir {
+			t.Errorf("NewPendingFile() #%d created the temporary file in %q, want %q", i, got, tmpdir)
+		}
+	}
+
+	// Changing TMPDIR invalidates the cache.
+	os.Setenv("TMPDIR", "/nonexistant")
+	opens := fs.count("open")
+	pf := create("bar")
+	if got := fs.count("open") - opens; got != 2 {
+		t.Errorf("NewPendingFile() after changing TMPDIR opened %d files, want 2", got)
+	}
+	if got := filepath.Dir(pf.Name()); got != dir {
+		t.Errorf("NewPendingFile() after changing TMPDIR created the temporary file in %q, want %q", got, dir)
+	}
+
+	// The negative decision is cached, too.
+	opens = fs.count("open")
+	create("bar")
+	if got := fs.count("open") - opens; got != 1 {
+		t.Errorf("NewPendingFile() opened %d files, want 1", got)
+	}
+
+	if got := cache.TempDir(dir); got != dir {
+		t.Errorf("TempDir(%q) = %q, want %q", dir, got, dir)
+	}
+}
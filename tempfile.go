@@ -18,44 +18,157 @@
 package renameio
 
 import (
-	"io/ioutil"
-	"math/rand"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 )
 
 // Default permissions for created files
 const defaultPerm os.FileMode = 0o600
 
-// nextrandom is a function generating a random number.
-var nextrandom = rand.Int63
+// Number of names tried by createTemp before giving up
+const maxTempAttempts = 10000
+
+// Marker included in the names of temporary files, see IsTempName
+const tempMarker = ".renameio-"
+
+// errPatternHasSeparator is returned for temporary file name patterns
+// containing a path separator.
+var errPatternHasSeparator = errors.New("pattern contains path separator")
+
+// TempNameExhaustedError is returned (wrapped) when no unused name for a
+// temporary file or directory could be found. It wraps os.ErrExist.
+type TempNameExhaustedError struct {
+	Dir      string // directory in which the temporary file was to be created
+	Attempts int    // number of names tried
+}
+
+func (e *TempNameExhaustedError) Error() string {
+	return fmt.Sprintf("no unused temporary name found in %s after %d attempts", e.Dir, e.Attempts)
+}
+
+func (e *TempNameExhaustedError) Unwrap() error {
+	return os.ErrExist
+}
 
 // openTempFile creates a randomly named file and returns an open handle. It is
-// similar to ioutil.TempFile except that the directory must be given, the file
-// permissions can be controlled and patterns in the name are not supported.
-// The name is always suffixed with a random number.
-func openTempFile(dir, name string, perm os.FileMode) (*os.File, error) {
-	prefix := filepath.Join(dir, name)
-
-	for attempt := 0; ; {
-		// Generate a reasonably random name which is unlikely to already
-		// exist. O_EXCL ensures that existing files generate an error.
-		name := prefix + strconv.FormatInt(nextrandom(), 10)
-
-		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
-		if !os.IsExist(err) {
-			return f, err
+// similar to ioutil.TempFile except that the directory must be given and the
+// file permissions can be controlled. The name is generated from pattern using
+// random, see createTemp.
+func openTempFile(fs FS, random io.Reader, dir, pattern string, perm os.FileMode) (File, error) {
+	var f File
+	_, err := createTemp(random, filepath.Join(dir, pattern), func(name string) error {
+		var err error
+		// O_EXCL ensures that existing files generate an error.
+		f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		return err
+	})
+	return f, err
+}
+
+// createTemp calls create with randomly chosen names until it succeeds or
+// fails with an error not wrapping os.ErrExist, and returns the name used.
+// create must fail if the name already exists. The names are generated by
+// replacing the last "*" in pattern with a random number read from random, or
+// by appending one if pattern contains no "*". If random is nil,
+// crypto/rand.Reader is used.
+func createTemp(random io.Reader, pattern string, create func(name string) error) (string, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	for attempt := 0; attempt < maxTempAttempts; attempt++ {
+		// Generate a name which is very unlikely to already exist, even if
+		// other processes are creating temporary files at the same time.
+		n, err := randomNumber(random)
+		if err != nil {
+			return "", err
 		}
+		name := prefix + strconv.FormatInt(n, 10) + suffix
 
-		if attempt++; attempt > 10000 {
-			return nil, &os.PathError{
-				Op:   "tempfile",
-				Path: name,
-				Err:  os.ErrExist,
-			}
+		if err := create(name); err == nil {
+			return name, nil
+		} else if !os.IsExist(err) {
+			return "", err
 		}
 	}
+	return "", &TempNameExhaustedError{Dir: filepath.Dir(pattern), Attempts: maxTempAttempts}
+}
+
+// randomNumber returns a non-negative random number read from random, or from
+// crypto/rand.Reader if random is nil.
+func randomNumber(random io.Reader) (int64, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(random, b[:]); err != nil {
+		return 0, fmt.Errorf("reading random number: %w", err)
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) &^ (1 << 63)), nil
+}
+
+// tempPattern returns the default pattern for the names of temporary files
+// destined for base: a dot, base, the marker and the process ID, followed by
+// the random number.
+func tempPattern(base string) string {
+	return "." + base + tempMarker + strconv.Itoa(os.Getpid()) + "-*"
+}
+
+// IsTempName reports whether the file name (or the final element of the path)
+// name follows the default naming scheme for temporary files, i.e.
+// ".<base>.renameio-<pid>-<random number>". Names configured using
+// WithTempPattern are not recognized.
+func IsTempName(name string) bool {
+	name = filepath.Base(name)
+	i := strings.LastIndex(name, tempMarker)
+	if i < 1 || name[0] != '.' {
+		return false
+	}
+	parts := strings.Split(name[i+len(tempMarker):], "-")
+	if len(parts) != 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// syncDir opens the directory dir and calls fsync(2) on it, making the
+// directory entries (e.g. those created or changed by a rename) durable.
+func syncDir(fs FS, dir string) error {
+	d, err := fs.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// linkNoReplace moves oldpath to newpath using link(2) and unlink(2), which
+// fails with an error wrapping os.ErrExist if newpath already exists.
+func linkNoReplace(fs FS, oldpath, newpath string) error {
+	if err := fs.Link(oldpath, newpath); err != nil {
+		return err
+	}
+	return fs.Remove(oldpath)
 }
 
 // TempDir checks whether os.TempDir() can be used as a temporary directory for
@@ -65,11 +178,14 @@ func openTempFile(dir, name string, perm os.FileMode) (*os.File, error) {
 // Note that the returned value ceases to be valid once either os.TempDir()
 // changes (e.g. on Linux, once the TMPDIR environment variable changes) or the
 // file system is unmounted.
-func TempDir(dest string) string {
-	return tempDir("", filepath.Join(dest, "renameio-TempDir"))
+//
+// Of the options, only WithFS is used.
+func TempDir(dest string, opts ...Option) string {
+	cfg := newConfig(dest, opts)
+	return tempDir(cfg.fs, "", filepath.Join(dest, "renameio-TempDir"))
 }
 
-func tempDir(dir, dest string) string {
+func tempDir(fs FS, dir, dest string) string {
 	if dir != "" {
 		return dir // caller-specified directory always wins
 	}
@@ -83,26 +199,26 @@ func tempDir(dir, dest string) string {
 	// the TMPDIR environment variable.
 	tmpdir := os.TempDir()
 
-	testsrc, err := ioutil.TempFile(tmpdir, "."+filepath.Base(dest))
+	testsrc, err := openTempFile(fs, nil, tmpdir, tempPattern(filepath.Base(dest)), defaultPerm)
 	if err != nil {
 		return fallback
 	}
 	cleanup := true
 	defer func() {
 		if cleanup {
-			os.Remove(testsrc.Name())
+			fs.Remove(testsrc.Name())
 		}
 	}()
 	testsrc.Close()
 
-	testdest, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest))
+	testdest, err := openTempFile(fs, nil, filepath.Dir(dest), tempPattern(filepath.Base(dest)), defaultPerm)
 	if err != nil {
 		return fallback
 	}
-	defer os.Remove(testdest.Name())
+	defer fs.Remove(testdest.Name())
 	testdest.Close()
 
-	if err := os.Rename(testsrc.Name(), testdest.Name()); err != nil {
+	if err := fs.Rename(testsrc.Name(), testdest.Name()); err != nil {
 		return fallback
 	}
 	cleanup = false // testsrc no longer exists
@@ -111,13 +227,62 @@ func tempDir(dir, dest string) string {
 
 // PendingFile is a pending temporary file, waiting to replace the destination
 // path in a call to CloseAtomicallyReplace.
+//
+// The embedded *os.File is nil if the PendingFile was created on a file system
+// other than OSFS (see WithFS); use the methods of PendingFile itself instead.
 type PendingFile struct {
 	*os.File
 
+	fs     FS
+	f      File
+	random io.Reader
+
 	path           string
 	done           bool
 	closed         bool
 	replaceOnClose bool
+	syncDir        bool
+
+	// mu serializes committing and cleaning up, which may be triggered
+	// concurrently by the cancellation of ctx.
+	mu   sync.Mutex
+	ctx  context.Context
+	stop chan struct{}
+
+	// anonymous is set for files created using O_TMPFILE, which have no name
+	// until linked is set by closeAtomically.
+	anonymous bool
+	linked    string
+
+	// backup is the path to hard-link the destination file to before
+	// replacing it, if any.
+	backup string
+
+	// keepVersions is the number of previous versions of the destination
+	// file to keep, see rotateVersions.
+	keepVersions     int
+	compressVersions bool
+
+	skipIfUnchanged bool
+	unchanged       bool
+
+	hash     hash.Hash
+	expected *expectedDigest
+	sidecar  bool
+
+	// beforeRename, if set, is called by closeAtomically before touching
+	// backups and versions and again right before moving the temporary file
+	// into place, and aborts the commit on error.
+	beforeRename func() error
+}
+
+// tempPath returns the path of the temporary file, or the empty string for an
+// anonymous file which has not been linked yet.
+func (t *PendingFile) tempPath() string {
+	if t.anonymous {
+		return t.linked
+	}
+	return t.Name()
 }
 
 // Cleanup is a no-op if CloseAtomicallyReplace succeeded, and otherwise closes
@@ -125,6 +290,9 @@ type PendingFile struct {
 //
 // This method is not safe for concurrent use by multiple goroutines.
 func (t *PendingFile) Cleanup() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if t.done {
 		return nil
 	}
@@ -132,12 +300,16 @@ func (t *PendingFile) Cleanup() error {
 	// reporting, there is nothing the caller can recover here.
 	var closeErr error
 	if !t.closed {
-		closeErr = t.File.Close()
+		closeErr = t.f.Close()
+		t.closed = true
 	}
-	if err := os.Remove(t.Name()); err != nil {
-		return err
+	if name := t.tempPath(); name != "" {
+		if err := t.fs.Remove(name); err != nil {
+			return err
+		}
 	}
 	t.done = true
+	t.release()
 	return closeErr
 }
 
@@ -146,8 +318,104 @@ func (t *PendingFile) Cleanup() error {
 // open the file previously located at the destination path (if any), or the
 // just written file, but the file will always be present.
 //
+// Unless the PendingFile was created with WithSyncDir, the rename itself is not
+// guaranteed to be durable and may be lost after a power failure.
+//
 // This method is not safe for concurrent use by multiple goroutines.
 func (t *PendingFile) CloseAtomicallyReplace() error {
+	return t.closeAtomically(renameFS, true)
+}
+
+// CloseAtomicallyCreate closes the temporary file and atomically moves it to
+// the destination path, but only if no file exists there yet. Otherwise an
+// error wrapping os.ErrExist is returned and the temporary file is left in
+// place for Cleanup to remove.
+//
+// On Linux, renameat2(2) with RENAME_NOREPLACE is used. On other platforms and
+// file systems not supporting it, the temporary file is hard-linked to the
+// destination and then removed. A file system passed using WithFS can provide
+// its own implementation, see FS.
+//
+// As no file is replaced, WithBackup and WithVersionRotation have no effect.
+//
+// This method is not safe for concurrent use by multiple goroutines.
+func (t *PendingFile) CloseAtomicallyCreate() error {
+	return t.closeAtomically(renameNoReplace, false)
+}
+
+// closeAtomically syncs and closes the temporary file, then moves it to the
+// destination path using rename. Backups and versions of the destination are
+// only kept if rename replaces it.
+func (t *PendingFile) closeAtomically(rename func(fs FS, oldpath, newpath string) error, replace bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.prepare(); err != nil {
+		return err
+	}
+	if skip, err := t.skipUnchanged(); err != nil {
+		return err
+	} else if skip {
+		if t.sidecar {
+			return t.refreshSidecar()
+		}
+		return nil
+	}
+	// Do not touch backups and versions for a commit which is going to be
+	// aborted anyway.
+	if err := t.checkRename(); err != nil {
+		return err
+	}
+	if replace {
+		if err := t.rotateVersions(); err != nil {
+			return err
+		}
+		if err := t.backupDestination(); err != nil {
+			return err
+		}
+	}
+	sidecar, err := t.sidecarContent()
+	if err != nil {
+		return err
+	}
+	// Check once more right before the rename, after all potentially slow
+	// work is done.
+	if err := t.checkRename(); err != nil {
+		return err
+	}
+	if err := rename(t.fs, t.tempPath(), t.path); err != nil {
+		return err
+	}
+	t.done = true
+	t.release()
+	// The sidecar must never refer to content which could still be lost.
+	if t.syncDir || sidecar != nil {
+		if err := t.syncDirs(); err != nil {
+			return err
+		}
+	}
+	return t.writeSidecar(sidecar)
+}
+
+// checkRename returns an error if the destination must not be replaced: a
+// cancelled context must never result in that, and neither must a failing
+// beforeRename.
+func (t *PendingFile) checkRename() error {
+	if err := t.ctxErr(); err != nil {
+		return err
+	}
+	if t.beforeRename != nil {
+		return t.beforeRename()
+	}
+	return nil
+}
+
+// prepare syncs and closes the temporary file, giving it a name first if it is
+// anonymous. The caller must hold t.mu.
+func (t *PendingFile) prepare() error {
+	if err := t.ctxErr(); err != nil {
+		return err
+	}
 	// Even on an ordered file system (e.g. ext4 with data=ordered) or file
 	// systems with write barriers, we cannot skip the fsync(2) call as per
 	// Theodore Ts'o (ext2/3/4 lead developer):
@@ -159,24 +427,84 @@ func (t *PendingFile) CloseAtomicallyReplace() error {
 	if err := t.Sync(); err != nil {
 		return err
 	}
-	t.closed = true
-	if err := t.File.Close(); err != nil {
+	if err := t.verifyDigest(); err != nil {
 		return err
 	}
-	if err := os.Rename(t.Name(), t.path); err != nil {
+	if t.anonymous && t.linked == "" {
+		// Anonymous files can only be linked while still open.
+		name, err := linkAnonymousTempFile(t.File, t.random)
+		if err != nil {
+			return err
+		}
+		t.linked = name
+	}
+	t.closed = true
+	return t.f.Close()
+}
+
+// syncDirs makes the rename durable by syncing the destination directory and,
+// if the temporary file was created elsewhere, the temporary directory.
+func (t *PendingFile) syncDirs() error {
+	dir := filepath.Dir(t.path)
+	if err := syncDir(t.fs, dir); err != nil {
 		return err
 	}
-	t.done = true
+	if tmpdir := filepath.Dir(t.Name()); filepath.Clean(tmpdir) != filepath.Clean(dir) {
+		return syncDir(t.fs, tmpdir)
+	}
 	return nil
 }
 
+// Write writes len(b) bytes to the temporary file, adding them to the running
+// hash configured using WithHash, if any. It fails with ctx.Err() if the
+// PendingFile was created by NewPendingFileContext and its context has been
+// cancelled.
+func (t *PendingFile) Write(b []byte) (int, error) {
+	if err := t.ctxErr(); err != nil {
+		return 0, err
+	}
+	n, err := t.f.Write(b)
+	if t.hash != nil {
+		t.hash.Write(b[:n])
+	}
+	return n, err
+}
+
+// WriteString is like Write, but writes the contents of string s.
+func (t *PendingFile) WriteString(s string) (int, error) {
+	if t.hash != nil {
+		return t.Write([]byte(s))
+	}
+	if err := t.ctxErr(); err != nil {
+		return 0, err
+	}
+	return io.WriteString(t.f, s)
+}
+
+// ReadFrom implements io.ReaderFrom by reading from r until EOF and writing
+// the data to the temporary file. It uses the fast paths of (*os.File).ReadFrom,
+// such as copy_file_range(2) and splice(2) on Linux, if r is an *os.File and
+// no running hash is configured using WithHash.
+func (t *PendingFile) ReadFrom(r io.Reader) (int64, error) {
+	if err := t.ctxErr(); err != nil {
+		return 0, err
+	}
+	if t.hash != nil {
+		r = io.TeeReader(r, t.hash)
+	}
+	if t.File == nil {
+		return io.Copy(t.f, r)
+	}
+	return t.File.ReadFrom(r)
+}
+
 // Close closes the file. By default it just calls Close() on the underlying file. For PendingFiles created with
 // WithReplaceOnClose it calls CloseAtomicallyReplace() instead.
 func (t *PendingFile) Close() error {
 	if t.replaceOnClose {
 		return t.CloseAtomicallyReplace()
 	}
-	return t.File.Close()
+	return t.f.Close()
 }
 
 // TempFile creates a temporary file destined to atomically creating or
@@ -194,12 +522,40 @@ func TempFile(dir, path string) (*PendingFile, error) {
 }
 
 type config struct {
+	fs              FS
 	dir, path       string
+	tempPattern     string
+	random          io.Reader
+	tempDirCache    *TempDirCache
+	strictAtomicity bool
 	createPerm      os.FileMode
 	attemptPermCopy bool
 	ignoreUmask     bool
 	chmod           *os.FileMode
 	renameOnClose   bool
+	syncDir         bool
+	anonymous       bool
+	updateRetries   int
+
+	attemptOwnerCopy bool
+	owner            *fileOwner
+
+	attemptXattrCopy bool
+	xattrSource      string
+	xattrs           []xattr
+
+	backup       bool
+	backupSuffix string
+	backupDir    string
+
+	keepVersions     int
+	compressVersions bool
+
+	skipIfUnchanged bool
+
+	hash     hash.Hash
+	expected *expectedDigest
+	sidecar  bool
 }
 
 // NewPendingFile creates a temporary file destined to atomically creating or
@@ -213,82 +569,216 @@ type config struct {
 // IgnoreUmask, WithStaticPermissions and WithExistingPermissions to control
 // them.
 func NewPendingFile(path string, opts ...Option) (*PendingFile, error) {
+	cfg := newConfig(path, opts)
+
+	if cfg.ignoreUmask && cfg.chmod == nil {
+		cfg.chmod = &cfg.createPerm
+	}
+
+	if cfg.strictAtomicity && isOSFS(cfg.fs) {
+		if err := checkAtomicity(filepath.Dir(cfg.path)); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.attemptPermCopy || cfg.attemptOwnerCopy || cfg.attemptXattrCopy {
+		// Try to determine metadata from an existing file.
+		if existing, err := cfg.fs.Lstat(cfg.path); err == nil && existing.Mode().IsRegular() {
+			if cfg.attemptPermCopy {
+				perm := existing.Mode() & os.ModePerm
+				cfg.chmod = &perm
+
+				// Try to already create file with desired permissions; at
+				// worst a chmod will be needed afterwards.
+				cfg.createPerm = perm
+			}
+			if cfg.attemptOwnerCopy {
+				if owner, ok := ownerOf(existing); ok {
+					cfg.owner = &owner
+				}
+			}
+			if cfg.attemptXattrCopy {
+				cfg.xattrSource = cfg.path
+			}
+		} else if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	pattern := cfg.tempPattern
+	if pattern == "" {
+		pattern = tempPattern(filepath.Base(cfg.path))
+	} else if strings.ContainsRune(pattern, os.PathSeparator) {
+		return nil, &os.PathError{Op: "tempfile", Path: pattern, Err: errPatternHasSeparator}
+	}
+	var dir string
+	if cfg.dir == "" && cfg.tempDirCache != nil {
+		dir = cfg.tempDirCache.tempDir(cfg.fs, cfg.path)
+	} else {
+		dir = tempDir(cfg.fs, cfg.dir, cfg.path)
+	}
+
+	var f File
+	var anonymous bool
+	if cfg.anonymous && isOSFS(cfg.fs) {
+		var err error
+		var osf *os.File
+		if osf, anonymous, err = openAnonymousTempFile(dir, pattern, cfg.createPerm); err != nil {
+			return nil, err
+		}
+		if anonymous {
+			f = osf
+		}
+	}
+	if !anonymous {
+		var err error
+		if f, err = openTempFile(cfg.fs, cfg.random, dir, pattern, cfg.createPerm); err != nil {
+			return nil, err
+		}
+	}
+
+	osf, _ := f.(*os.File)
+	t := &PendingFile{
+		File:           osf,
+		fs:             cfg.fs,
+		f:              f,
+		random:         cfg.random,
+		path:           cfg.path,
+		replaceOnClose: cfg.renameOnClose,
+		syncDir:        cfg.syncDir,
+		anonymous:      anonymous,
+		backup:         backupPath(&cfg),
+
+		keepVersions:     cfg.keepVersions,
+		compressVersions: cfg.compressVersions,
+		skipIfUnchanged:  cfg.skipIfUnchanged,
+		hash:             cfg.hash,
+		expected:         cfg.expected,
+		sidecar:          cfg.sidecar,
+	}
+
+	if err := t.setMetadata(&cfg); err != nil {
+		t.Cleanup()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// newConfig returns the configuration for the destination path after applying
+// opts to the defaults.
+func newConfig(path string, opts []Option) config {
 	cfg := config{
+		fs:         OSFS{},
 		path:       path,
 		createPerm: defaultPerm,
 	}
-
 	for _, o := range opts {
 		o.apply(&cfg)
 	}
+	return cfg
+}
 
-	if cfg.ignoreUmask && cfg.chmod == nil {
-		cfg.chmod = &cfg.createPerm
+// setMetadata applies the ownership and permissions requested in cfg to the
+// temporary file.
+func (t *PendingFile) setMetadata(cfg *config) error {
+	if cfg.owner == nil && cfg.chmod == nil {
+		return t.setXattrs(cfg)
 	}
 
-	if cfg.attemptPermCopy {
-		// Try to determine permissions from an existing file.
-		if existing, err := os.Lstat(cfg.path); err == nil && existing.Mode().IsRegular() {
-			perm := existing.Mode() & os.ModePerm
-			cfg.chmod = &perm
+	fi, err := t.Stat()
+	if err != nil {
+		return err
+	}
 
-			// Try to already create file with desired permissions; at worst
-			// a chmod will be needed afterwards.
-			cfg.createPerm = perm
-		} else if err != nil && !os.IsNotExist(err) {
-			return nil, err
+	// Changing the owner may clear the setuid and setgid bits, so it must
+	// happen before the chmod.
+	if cfg.owner != nil {
+		if err := t.chown(fi, *cfg.owner); err != nil {
+			return err
 		}
 	}
 
-	f, err := openTempFile(tempDir(cfg.dir, cfg.path), "."+filepath.Base(cfg.path), cfg.createPerm)
-	if err != nil {
-		return nil, err
+	if cfg.chmod != nil && fi.Mode()&os.ModePerm != *cfg.chmod {
+		if err := t.Chmod(*cfg.chmod); err != nil {
+			return err
+		}
 	}
 
-	if cfg.chmod != nil {
-		if fi, err := f.Stat(); err != nil {
-			return nil, err
-		} else if fi.Mode()&os.ModePerm != *cfg.chmod {
-			if err := f.Chmod(*cfg.chmod); err != nil {
-				return nil, err
-			}
+	// Extended attributes come last as changing the owner removes some (e.g.
+	// security.capability) and POSIX ACLs must not be overridden by the chmod.
+	return t.setXattrs(cfg)
+}
+
+// setXattrs copies the extended attributes of an existing file and sets those
+// requested in cfg on the temporary file.
+func (t *PendingFile) setXattrs(cfg *config) error {
+	if t.File == nil {
+		// Extended attributes require an operating system file descriptor.
+		if len(cfg.xattrs) > 0 {
+			return &os.PathError{Op: "setxattr", Path: t.Name(), Err: syscall.ENOTSUP}
 		}
+		return nil
 	}
-
-	return &PendingFile{File: f, path: cfg.path, replaceOnClose: cfg.renameOnClose}, nil
+	if cfg.xattrSource != "" {
+		if err := copyXattrs(cfg.xattrSource, t.File); err != nil {
+			return err
+		}
+	}
+	for _, x := range cfg.xattrs {
+		if err := setXattr(t.File, x.name, x.value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Symlink wraps os.Symlink, replacing an existing symlink with the same name
 // atomically (os.Symlink fails when newname already exists, at least on Linux).
-func Symlink(oldname, newname string) error {
+// The directory containing newname is synced afterwards so that the new
+// symlink survives a crash.
+//
+// Of the options, only WithFS and WithRandomSource are used.
+func Symlink(oldname, newname string, opts ...Option) error {
+	cfg := newConfig(newname, opts)
+	fs := cfg.fs
+
 	// Fast path: if newname does not exist yet, we can skip the whole dance
 	// below.
-	if err := os.Symlink(oldname, newname); err == nil || !os.IsExist(err) {
+	if err := fs.Symlink(oldname, newname); err == nil {
+		return syncDir(fs, filepath.Dir(newname))
+	} else if !os.IsExist(err) {
 		return err
 	}
 
-	// We need to use ioutil.TempDir, as we cannot overwrite a ioutil.TempFile,
-	// and removing+symlinking creates a TOCTOU race.
-	d, err := ioutil.TempDir(filepath.Dir(newname), "."+filepath.Base(newname))
+	// We need to use a temporary directory, as we cannot overwrite a temporary
+	// file, and removing+symlinking creates a TOCTOU race.
+	d, err := createTemp(cfg.random, filepath.Join(filepath.Dir(newname), tempPattern(filepath.Base(newname))), func(name string) error {
+		return fs.Mkdir(name, 0o700)
+	})
 	if err != nil {
 		return err
 	}
+	symlink := filepath.Join(d, "tmp.symlink")
 	cleanup := true
 	defer func() {
 		if cleanup {
-			os.RemoveAll(d)
+			fs.Remove(symlink)
+			fs.Remove(d)
 		}
 	}()
 
-	symlink := filepath.Join(d, "tmp.symlink")
-	if err := os.Symlink(oldname, symlink); err != nil {
+	if err := fs.Symlink(oldname, symlink); err != nil {
 		return err
 	}
 
-	if err := os.Rename(symlink, newname); err != nil {
+	if err := fs.Rename(symlink, newname); err != nil {
 		return err
 	}
 
 	cleanup = false
-	return os.RemoveAll(d)
+	if err := fs.Remove(d); err != nil {
+		return err
+	}
+	return syncDir(fs, filepath.Dir(newname))
 }
@@ -15,6 +15,7 @@
 package renameio
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -101,9 +102,60 @@ func TestTempDir(t *testing.T) {
 			} else {
 				os.Setenv("TMPDIR", tt.TMPDIR)
 			}
-			if got := tempDir(tt.dir, tt.path); got != tt.want {
+			if got := tempDir(OSFS{}, tt.dir, tt.path); got != tt.want {
 				t.Fatalf("tempDir(%q, %q): got %q, want %q", tt.dir, tt.path, got, tt.want)
 			}
 		})
 	}
 }
+
+func TestAnonymousTempFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "anonymous.txt")
+
+	for _, commit := range []bool{false, true} {
+		pf, err := NewPendingFile(path, WithAnonymousTempFile())
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+		}
+		if !pf.anonymous {
+			t.Skip("O_TMPFILE not supported")
+		}
+
+		if _, err := pf.WriteString("anonymous"); err != nil {
+			t.Errorf("Write() failed: %v", err)
+		}
+
+		if entries, err := ioutil.ReadDir(dir); err != nil {
+			t.Errorf("ReadDir(%q) failed: %v", dir, err)
+		} else if len(entries) != 0 && !commit {
+			t.Errorf("Directory %q contains %d entries, want 0", dir, len(entries))
+		}
+
+		if commit {
+			if err := pf.CloseAtomicallyReplace(); err != nil {
+				t.Errorf("CloseAtomicallyReplace() failed: %v", err)
+			}
+		}
+
+		if err := pf.Cleanup(); err != nil {
+			t.Errorf("Cleanup() failed: %v", err)
+		}
+
+		if _, err := os.Stat(path); commit == errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Stat(%q) after commit=%v returned unexpected error: %v", path, commit, err)
+		}
+	}
+
+	if got, err := ioutil.ReadFile(path); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", path, err)
+	} else if want := "anonymous"; string(got) != want {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err != nil {
+		t.Errorf("ReadDir(%q) failed: %v", dir, err)
+	} else if len(entries) != 1 {
+		t.Errorf("Directory %q contains %d entries, want 1", dir, len(entries))
+	}
+}
@@ -18,11 +18,15 @@
 package renameio
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 )
@@ -37,28 +41,29 @@ func withUmask(t *testing.T, mask os.FileMode) {
 	})
 }
 
-func withCustomRng(t *testing.T, fn func() int64) {
-	t.Helper()
-
-	orig := nextrandom
-
-	t.Cleanup(func() {
-		nextrandom = orig
-	})
+// sequenceSource is a deterministic random source yielding the numbers next,
+// next+step, next+2*step, etc. as read by randomNumber.
+type sequenceSource struct {
+	next, step int64
+	buf        []byte
+}
 
-	nextrandom = fn
+func (s *sequenceSource) Read(b []byte) (int, error) {
+	for len(s.buf) < len(b) {
+		s.buf = append(s.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(s.buf[len(s.buf)-8:], uint64(s.next))
+		s.next += s.step
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
 }
 
 func TestOpenTempFile(t *testing.T) {
 	const count = 100
 
-	// Install a deterministic random generator
-	var next int64 = 12345
-	withCustomRng(t, func() int64 {
-		v := next
-		next++
-		return v
-	})
+	// Use a deterministic random source
+	random := &sequenceSource{next: 12345, step: 1}
 
 	for _, umask := range []os.FileMode{0o000, 0o011, 0o007, 0o027, 0o077} {
 		t.Run(fmt.Sprintf("0%o", umask), func(t *testing.T) {
@@ -70,7 +75,7 @@ func TestOpenTempFile(t *testing.T) {
 				perm := [...]os.FileMode{0600, 0755, 0411}[i%3]
 				maskedPerm := perm & ^umask
 
-				got, err := openTempFile(dir, "test", perm)
+				got, err := openTempFile(OSFS{}, random, dir, "test", perm)
 				if err != nil {
 					t.Errorf("openTempFile() failed: %v", err)
 				}
@@ -101,19 +106,22 @@ func TestOpenTempFileConflict(t *testing.T) {
 	withUmask(t, 0077)
 
 	// https://xkcd.com/221/
-	withCustomRng(t, func() int64 {
-		return 4
-	})
+	random := &sequenceSource{next: 4}
 
 	dir := t.TempDir()
 
-	if first, err := openTempFile(dir, "test", 0644); err != nil {
+	if first, err := openTempFile(OSFS{}, random, dir, "test", 0644); err != nil {
 		t.Errorf("openTempFile() failed: %v", err)
 	} else {
 		first.Close()
 	}
 
-	if _, err := openTempFile(dir, "test", 0644); !errors.Is(err, os.ErrExist) {
+	var exhausted *TempNameExhaustedError
+	if _, err := openTempFile(OSFS{}, random, dir, "test", 0644); !errors.As(err, &exhausted) {
+		t.Errorf("openTempFile() did not fail with TempNameExhaustedError: %v", err)
+	} else if exhausted.Dir != dir {
+		t.Errorf("TempNameExhaustedError.Dir = %q, want %q", exhausted.Dir, dir)
+	} else if !errors.Is(err, os.ErrExist) {
 		t.Errorf("openTempFile() did not fail with ErrExist: %v", err)
 	}
 }
@@ -229,6 +237,27 @@ func TestPendingFileCreation(t *testing.T) {
 			want:     "replaced:custom tempdir",
 			wantPerm: 0o612,
 		},
+		{
+			name:     "sync dir",
+			path:     filepath.Join(t.TempDir(), "sync dir"),
+			options:  []Option{WithSyncDir()},
+			want:     "replaced:sync dir",
+			wantPerm: 0o600,
+		},
+		{
+			name:     "sync dir with custom tempdir",
+			path:     filepath.Join(t.TempDir(), "sync dir"),
+			options:  []Option{WithSyncDir(), WithTempDir(t.TempDir())},
+			want:     "replaced:sync dir with custom tempdir",
+			wantPerm: 0o600,
+		},
+		{
+			name:     "anonymous",
+			path:     filepath.Join(t.TempDir(), "anonymous"),
+			options:  []Option{WithAnonymousTempFile(), WithPermissions(0o640)},
+			want:     "replaced:anonymous",
+			wantPerm: 0o600,
+		},
 		{
 			name:     "ignore umask",
 			path:     filepath.Join(t.TempDir(), "ignore umask"),
@@ -389,3 +418,162 @@ func TestTempFileNoCommit(t *testing.T) {
 		t.Errorf("Read unexpected content %q from %q, want %q", string(got), pathExisting, want)
 	}
 }
+
+func TestSyncDir(t *testing.T) {
+	if err := syncDir(OSFS{}, t.TempDir()); err != nil {
+		t.Errorf("syncDir() failed: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	if err := syncDir(OSFS{}, missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("syncDir(%q) did not fail with ErrNotExist: %v", missing, err)
+	}
+}
+
+func TestCloseAtomicallyCreate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lock")
+
+	for i, want := range []error{nil, os.ErrExist} {
+		pf, err := NewPendingFile(path)
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+		}
+
+		if _, err := fmt.Fprintf(pf, "attempt %d", i); err != nil {
+			t.Errorf("Write() failed: %v", err)
+		}
+
+		if err := pf.CloseAtomicallyCreate(); !errors.Is(err, want) {
+			t.Errorf("CloseAtomicallyCreate() attempt %d returned %v, want %v", i, err, want)
+		}
+
+		if err := pf.Cleanup(); err != nil {
+			t.Errorf("Cleanup() failed: %v", err)
+		}
+	}
+
+	if got, err := ioutil.ReadFile(path); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", path, err)
+	} else if want := "attempt 0"; string(got) != want {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err != nil {
+		t.Errorf("ReadDir(%q) failed: %v", dir, err)
+	} else if len(entries) != 1 {
+		t.Errorf("Directory %q contains %d entries, want 1", dir, len(entries))
+	}
+}
+
+func TestLinkNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	for _, path := range []string{src, dst} {
+		if err := ioutil.WriteFile(path, []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := linkNoReplace(OSFS{}, src, dst); !errors.Is(err, os.ErrExist) {
+		t.Errorf("linkNoReplace(%q, %q) did not fail with ErrExist: %v", src, dst, err)
+	}
+
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := linkNoReplace(OSFS{}, src, dst); err != nil {
+		t.Errorf("linkNoReplace(%q, %q) failed: %v", src, dst, err)
+	}
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Stat(%q) didn't report that file doesn't exist: %v", src, err)
+	}
+
+	if got, err := ioutil.ReadFile(dst); err != nil {
+		t.Errorf("ReadFile(%q) failed: %v", dst, err)
+	} else if string(got) != src {
+		t.Errorf("Read unexpected content %q from %q, want %q", string(got), dst, src)
+	}
+}
+
+func TestIsTempName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{".foo.txt.renameio-1234-8674665223082153551", true},
+		{"/some/dir/.foo.renameio-1-2", true},
+		{".foo.renameio-1234", false},
+		{".foo.renameio-1234-", false},
+		{".foo.renameio-txn-8674665223082153551", false},
+		{"foo.renameio-1234-8674665223082153551", false},
+		{".renameio-1234-8674665223082153551", false},
+		{".foo.txt8674665223082153551", false},
+	} {
+		if got := IsTempName(tc.name); got != tc.want {
+			t.Errorf("IsTempName(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	pf, err := NewPendingFile(filepath.Join(t.TempDir(), "foo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Cleanup()
+	if !IsTempName(pf.Name()) {
+		t.Errorf("IsTempName(%q) = false for the default name, want true", pf.Name())
+	}
+}
+
+func TestWithTempPattern(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.txt")
+
+	for _, tc := range []struct {
+		pattern, prefix, suffix string
+	}{
+		{"tmp-*.part", "tmp-", ".part"},
+		{"~foo-", "~foo-", ""},
+	} {
+		pf, err := NewPendingFile(path, WithTempDir(dir), WithTempPattern(tc.pattern))
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q, WithTempPattern(%q)) failed: %v", path, tc.pattern, err)
+		}
+		name := filepath.Base(pf.Name())
+		if !strings.HasPrefix(name, tc.prefix) || !strings.HasSuffix(name, tc.suffix) || len(name) == len(tc.prefix)+len(tc.suffix) {
+			t.Errorf("WithTempPattern(%q) resulted in name %q, want %q + random number + %q", tc.pattern, name, tc.prefix, tc.suffix)
+		}
+		if IsTempName(name) {
+			t.Errorf("IsTempName(%q) = true for a custom pattern, want false", name)
+		}
+		pf.Cleanup()
+	}
+
+	if _, err := NewPendingFile(path, WithTempPattern("a/*")); !errors.Is(err, errPatternHasSeparator) {
+		t.Errorf("NewPendingFile(%q, WithTempPattern(%q)) did not fail with errPatternHasSeparator: %v", path, "a/*", err)
+	}
+}
+
+func TestWithRandomSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.txt")
+
+	pf, err := NewPendingFile(path, WithTempDir(dir), WithRandomSource(&sequenceSource{next: 42}))
+	if err != nil {
+		t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+	}
+	defer pf.Cleanup()
+
+	if want := filepath.Join(dir, strings.TrimSuffix(tempPattern("foo.txt"), "*")+"42"); pf.Name() != want {
+		t.Errorf("Name() = %q, want %q", pf.Name(), want)
+	}
+
+	if _, err := NewPendingFile(path, WithRandomSource(bytes.NewReader(nil))); !errors.Is(err, io.EOF) {
+		t.Errorf("NewPendingFile(%q) with an empty random source did not fail with EOF: %v", path, err)
+	}
+}
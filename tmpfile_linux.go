@@ -0,0 +1,62 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// openAnonymousTempFile creates an unnamed file in dir using O_TMPFILE. The
+// returned file's Name() is filepath.Join(dir, name), which is used as the
+// pattern for the name given to it by linkAnonymousTempFile. If ok is false,
+// the kernel or file system does not support O_TMPFILE and the caller should
+// fall back to openTempFile.
+func openAnonymousTempFile(dir, name string, perm os.FileMode) (f *os.File, ok bool, err error) {
+	fd, err := unix.Open(dir, unix.O_RDWR|unix.O_TMPFILE|unix.O_CLOEXEC, uint32(perm))
+	switch err {
+	case nil:
+	case unix.EOPNOTSUPP, unix.EISDIR:
+		// Kernels before 3.11 ignore O_TMPFILE and fail with EISDIR.
+		return nil, false, nil
+	default:
+		return nil, true, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir, name)), true, nil
+}
+
+// linkAnonymousTempFile gives the file created by openAnonymousTempFile a
+// randomly chosen name generated from the pattern f.Name() using random and
+// returns it.
+func linkAnonymousTempFile(f *os.File, random io.Reader) (string, error) {
+	fd := int(f.Fd())
+
+	return createTemp(random, f.Name(), func(name string) error {
+		// AT_EMPTY_PATH requires CAP_DAC_READ_SEARCH, the /proc/self/fd path
+		// works for unprivileged processes as long as /proc is mounted.
+		err := unix.Linkat(fd, "", unix.AT_FDCWD, name, unix.AT_EMPTY_PATH)
+		if err == unix.ENOENT || err == unix.EPERM {
+			err = unix.Linkat(unix.AT_FDCWD, "/proc/self/fd/"+strconv.Itoa(fd), unix.AT_FDCWD, name, unix.AT_SYMLINK_FOLLOW)
+		}
+		if err != nil {
+			return &os.LinkError{Op: "linkat", Old: f.Name(), New: name, Err: err}
+		}
+		return nil
+	})
+}
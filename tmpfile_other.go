@@ -0,0 +1,36 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package renameio
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// openAnonymousTempFile always reports O_TMPFILE as unsupported on this
+// platform.
+func openAnonymousTempFile(dir, name string, perm os.FileMode) (f *os.File, ok bool, err error) {
+	return nil, false, nil
+}
+
+// linkAnonymousTempFile is never called on this platform as
+// openAnonymousTempFile never succeeds.
+func linkAnonymousTempFile(f *os.File, random io.Reader) (string, error) {
+	return "", &os.PathError{Op: "linkat", Path: f.Name(), Err: syscall.ENOTSUP}
+}
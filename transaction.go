@@ -0,0 +1,314 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Prefix of the intent journals written by Transaction.Commit
+const journalPrefix = ".renameio-txn-"
+
+// errTransactionOption is returned by Transaction.Commit for PendingFiles
+// configured with options it cannot honor.
+var errTransactionOption = errors.New("option not supported in a transaction")
+
+// journal records the renames a transaction is about to perform.
+type journal struct {
+	Files []journalEntry `json:"files"`
+}
+
+type journalEntry struct {
+	Temp string `json:"temp"`
+	Path string `json:"path"`
+}
+
+// Transaction commits a set of PendingFiles together: after a crash, Recover
+// makes sure that either all or none of them replaced their destination.
+//
+// A Transaction is not safe for concurrent use by multiple goroutines.
+type Transaction struct {
+	dir   string
+	files []*PendingFile
+	done  bool
+}
+
+// NewTransaction returns an empty transaction which stores its intent journal
+// in dir. Recover must be called with the same dir after a crash, e.g. when the
+// program starts up. Sweep only keeps the temporary files of an interrupted
+// transaction if they are in the same directory as its journal.
+func NewTransaction(dir string) *Transaction {
+	return &Transaction{dir: dir}
+}
+
+// Add makes t part of the transaction. Its temporary file must not be
+// committed or cleaned up by the caller, this is done by Commit and Cleanup.
+// Adding the same PendingFile more than once has no effect.
+func (tx *Transaction) Add(t *PendingFile) {
+	for _, other := range tx.files {
+		if other == t {
+			return
+		}
+	}
+	tx.files = append(tx.files, t)
+}
+
+// Commit syncs and closes all temporary files and atomically replaces their
+// destinations with them.
+//
+// To make the transaction crash-safe, the temporary files and a journal
+// listing them are made durable before any destination is replaced. Once the
+// journal is in place, the transaction is rolled forward by Recover if Commit
+// does not complete. If Commit fails after writing the journal, Recover should
+// be called to finish the transaction.
+//
+// All directories involved are synced, as if WithSyncDir was given. PendingFiles
+// created with WithBackup, WithBackupDir, WithVersionRotation,
+// WithSkipIfUnchanged or WithChecksumSidecar are not supported and cause Commit
+// to fail before any file is touched.
+func (tx *Transaction) Commit() error {
+	if tx.done {
+		return errors.New("transaction already committed or cleaned up")
+	}
+
+	for _, t := range tx.files {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+
+	for _, t := range tx.files {
+		if t.done {
+			return &os.PathError{Op: "commit", Path: t.path, Err: os.ErrClosed}
+		}
+		if !isOSFS(t.fs) {
+			// Recover works on the operating system's file system only.
+			return &os.PathError{Op: "commit", Path: t.path, Err: syscall.ENOTSUP}
+		}
+		if t.backup != "" || t.keepVersions > 0 || t.skipIfUnchanged || t.sidecar {
+			return &os.PathError{Op: "commit", Path: t.path, Err: errTransactionOption}
+		}
+	}
+
+	var j journal
+	for _, t := range tx.files {
+		if err := t.prepare(); err != nil {
+			return err
+		}
+
+		temp, err := filepath.Abs(t.tempPath())
+		if err != nil {
+			return err
+		}
+		path, err := filepath.Abs(t.path)
+		if err != nil {
+			return err
+		}
+		j.Files = append(j.Files, journalEntry{Temp: temp, Path: path})
+	}
+
+	// The temporary files need to survive a crash for Recover to be able to
+	// roll the transaction forward.
+	tempDirs := map[string]bool{}
+	for _, e := range j.Files {
+		tempDirs[filepath.Dir(e.Temp)] = true
+	}
+	if err := syncDirs(tempDirs); err != nil {
+		return err
+	}
+
+	for _, t := range tx.files {
+		if err := t.ctxErr(); err != nil {
+			return err
+		}
+	}
+
+	name, err := writeJournal(tx.dir, &j)
+	if err != nil {
+		return err
+	}
+
+	// Point of no return: from here on, the transaction is rolled forward.
+	tx.done = true
+	for _, t := range tx.files {
+		t.done = true
+		t.release()
+	}
+
+	return applyJournal(name, &j)
+}
+
+// Cleanup is a no-op if Commit was called, and otherwise cleans up all
+// PendingFiles in the transaction.
+func (tx *Transaction) Cleanup() error {
+	if tx.done {
+		return nil
+	}
+	var firstErr error
+	for _, t := range tx.files {
+		if err := t.Cleanup(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	tx.done = true
+	return firstErr
+}
+
+// Recover completes all transactions whose journal was written to dir but
+// which were interrupted, e.g. by a crash, before all destination files were
+// replaced. Transactions interrupted before writing their journal did not
+// modify any destination file and need no recovery; their temporary files are
+// left behind.
+func Recover(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(fi.Name(), journalPrefix) {
+			continue
+		}
+
+		name := filepath.Join(dir, fi.Name())
+		j, err := readJournal(name)
+		if err != nil {
+			return err
+		}
+		if err := applyJournal(name, j); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readJournal reads the journal at name.
+func readJournal(name string) (*journal, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var j journal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, &os.PathError{Op: "read journal", Path: name, Err: err}
+	}
+	return &j, nil
+}
+
+// journalTemps returns the temporary files listed in the journals in dir,
+// both as recorded and with symlinks in their directory resolved.
+func journalTemps(dir string) (map[string]bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	temps := map[string]bool{}
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(fi.Name(), journalPrefix) {
+			continue
+		}
+		j, err := readJournal(filepath.Join(dir, fi.Name()))
+		if os.IsNotExist(err) {
+			continue // completed concurrently
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range j.Files {
+			temps[e.Temp] = true
+			if resolved, err := filepath.EvalSymlinks(filepath.Dir(e.Temp)); err == nil {
+				temps[filepath.Join(resolved, filepath.Base(e.Temp))] = true
+			}
+		}
+	}
+	return temps, nil
+}
+
+// writeJournal durably writes j to a new journal file in dir and returns its
+// name. No journal is left behind if an error is returned.
+func writeJournal(dir string, j *journal) (string, error) {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := randomNumber(nil)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, journalPrefix+strconv.FormatInt(n, 10))
+
+	t, err := NewPendingFile(name, WithTempDir(dir), WithSyncDir())
+	if err != nil {
+		return "", err
+	}
+	defer t.Cleanup()
+
+	if _, err := t.Write(b); err != nil {
+		return "", err
+	}
+
+	if err := t.CloseAtomicallyCreate(); err != nil {
+		if t.done {
+			// The journal is in place, but may not be durable.
+			os.Remove(name)
+		}
+		return "", err
+	}
+
+	return name, nil
+}
+
+// applyJournal moves all temporary files listed in the journal at name which
+// still exist to their destination, then removes the journal.
+func applyJournal(name string, j *journal) error {
+	dirs := map[string]bool{}
+	for _, e := range j.Files {
+		if err := os.Rename(e.Temp, e.Path); err == nil {
+			dirs[filepath.Dir(e.Path)] = true
+			dirs[filepath.Dir(e.Temp)] = true
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := syncDirs(dirs); err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return syncDir(OSFS{}, filepath.Dir(name))
+}
+
+// syncDirs calls syncDir for each of dirs.
+func syncDirs(dirs map[string]bool) error {
+	for dir := range dirs {
+		if err := syncDir(OSFS{}, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTransactionFiles creates old versions of the given paths and returns a
+// transaction replacing them with new versions.
+func newTransactionFiles(t *testing.T, journalDir string, paths ...string) *Transaction {
+	t.Helper()
+
+	tx := NewTransaction(journalDir)
+	for _, path := range paths {
+		if err := ioutil.WriteFile(path, []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		pf, err := NewPendingFile(path, WithTempDir(filepath.Dir(path)))
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+		}
+		if _, err := pf.WriteString("new"); err != nil {
+			t.Errorf("WriteString() failed: %v", err)
+		}
+		tx.Add(pf)
+	}
+	return tx
+}
+
+func checkContents(t *testing.T, want string, paths ...string) {
+	t.Helper()
+
+	for _, path := range paths {
+		if got, err := ioutil.ReadFile(path); err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", path, err)
+		} else if string(got) != want {
+			t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+		}
+	}
+}
+
+func checkEntries(t *testing.T, dir string, want int) {
+	t.Helper()
+
+	if entries, err := ioutil.ReadDir(dir); err != nil {
+		t.Errorf("ReadDir(%q) failed: %v", dir, err)
+	} else if len(entries) != want {
+		t.Errorf("Directory %q contains %d entries, want %d", dir, len(entries), want)
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	paths := []string{
+		filepath.Join(dir1, "index"),
+		filepath.Join(dir1, "shard-0"),
+		filepath.Join(dir2, "shard-1"),
+	}
+
+	tx := newTransactionFiles(t, dir1, paths...)
+	defer tx.Cleanup()
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	checkContents(t, "new", paths...)
+	checkEntries(t, dir1, 2)
+	checkEntries(t, dir2, 1)
+
+	if err := tx.Commit(); err == nil {
+		t.Errorf("Commit() of committed transaction succeeded")
+	}
+}
+
+func TestTransactionAddTwice(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+
+	tx := newTransactionFiles(t, dir, path)
+	defer tx.Cleanup()
+	tx.Add(tx.files[0])
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	checkContents(t, "new", path)
+	checkEntries(t, dir, 1)
+}
+
+func TestTransactionContext(t *testing.T) {
+	dir, journalDir := t.TempDir(), t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "b"),
+	}
+
+	tx := newTransactionFiles(t, journalDir, paths[0])
+	defer tx.Cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pf, err := NewPendingFileContext(ctx, paths[1])
+	if err != nil {
+		t.Fatalf("NewPendingFileContext(%q) failed: %v", paths[1], err)
+	}
+	tx.Add(pf)
+	cancel()
+
+	if err := tx.Commit(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Commit() after cancel did not fail with context.Canceled: %v", err)
+	}
+
+	checkContents(t, "old", paths[0])
+	checkEntries(t, journalDir, 0)
+}
+
+func TestTransactionUnsupportedOption(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		option Option
+	}{
+		{"backup", WithBackup("")},
+		{"versions", WithVersionRotation(2)},
+		{"skip if unchanged", WithSkipIfUnchanged()},
+		{"sidecar", WithChecksumSidecar()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := []string{
+				filepath.Join(dir, "a"),
+				filepath.Join(dir, "b"),
+			}
+
+			tx := newTransactionFiles(t, dir, paths[0])
+			defer tx.Cleanup()
+
+			pf, err := NewPendingFile(paths[1], tc.option)
+			if err != nil {
+				t.Fatalf("NewPendingFile(%q) failed: %v", paths[1], err)
+			}
+			tx.Add(pf)
+
+			if err := tx.Commit(); !errors.Is(err, errTransactionOption) {
+				t.Errorf("Commit() = %v, want %v", err, errTransactionOption)
+			}
+
+			checkContents(t, "old", paths[0])
+			if err := tx.Cleanup(); err != nil {
+				t.Errorf("Cleanup() failed: %v", err)
+			}
+			checkEntries(t, dir, 1)
+		})
+	}
+}
+
+func TestTransactionCleanup(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "b"),
+	}
+
+	tx := newTransactionFiles(t, dir, paths...)
+
+	if err := tx.Cleanup(); err != nil {
+		t.Errorf("Cleanup() failed: %v", err)
+	}
+
+	checkContents(t, "old", paths...)
+	checkEntries(t, dir, 2)
+}
+
+// crashTransaction simulates a crash of tx.Commit after the journal was
+// written and the first file was moved into place.
+func crashTransaction(t *testing.T, tx *Transaction) {
+	t.Helper()
+
+	var j journal
+	for _, pf := range tx.files {
+		if err := pf.prepare(); err != nil {
+			t.Fatalf("prepare() failed: %v", err)
+		}
+		j.Files = append(j.Files, journalEntry{Temp: pf.tempPath(), Path: pf.path})
+	}
+	if _, err := writeJournal(tx.dir, &j); err != nil {
+		t.Fatalf("writeJournal() failed: %v", err)
+	}
+	if err := os.Rename(j.Files[0].Temp, j.Files[0].Path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionRecover(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "b"),
+		filepath.Join(dir, "c"),
+	}
+
+	crashTransaction(t, newTransactionFiles(t, dir, paths...))
+
+	checkContents(t, "new", paths[0])
+	checkContents(t, "old", paths[1:]...)
+
+	if err := Recover(dir); err != nil {
+		t.Fatalf("Recover(%q) failed: %v", dir, err)
+	}
+
+	checkContents(t, "new", paths...)
+	checkEntries(t, dir, len(paths))
+
+	// Recovering again is a no-op.
+	if err := Recover(dir); err != nil {
+		t.Fatalf("Recover(%q) failed: %v", dir, err)
+	}
+}
+
+func TestTransactionSweepRecover(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "b"),
+	}
+
+	crashTransaction(t, newTransactionFiles(t, dir, paths...))
+
+	if removed, err := Sweep(dir, WithMinAge(0)); err != nil {
+		t.Fatalf("Sweep(%q) failed: %v", dir, err)
+	} else if len(removed) != 0 {
+		t.Errorf("Sweep(%q) removed %q, want none", dir, removed)
+	}
+
+	if err := Recover(dir); err != nil {
+		t.Fatalf("Recover(%q) failed: %v", dir, err)
+	}
+
+	checkContents(t, "new", paths...)
+	checkEntries(t, dir, len(paths))
+}
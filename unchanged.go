@@ -0,0 +1,105 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256" // for crypto.SHA256
+	"os"
+)
+
+// Unchanged reports whether committing the PendingFile was skipped because the
+// destination file already had the same content (see WithSkipIfUnchanged).
+func (t *PendingFile) Unchanged() bool {
+	return t.unchanged
+}
+
+// skipUnchanged removes the temporary file and returns true if skipping was
+// requested and the destination already has the same content. The caller must
+// hold t.mu.
+func (t *PendingFile) skipUnchanged() (bool, error) {
+	if !t.skipIfUnchanged {
+		return false, nil
+	}
+
+	same, err := sameContent(t.fs, t.tempPath(), t.path)
+	if err != nil || !same {
+		return false, err
+	}
+
+	if err := t.fs.Remove(t.tempPath()); err != nil {
+		return false, err
+	}
+	t.done = true
+	t.unchanged = true
+	t.release()
+	return true, nil
+}
+
+// sameContent reports whether the regular file at path has the same content
+// as the regular file at other, comparing their sizes first and their SHA-256
+// digests second. A missing other is reported as different.
+func sameContent(fs FS, path, other string) (bool, error) {
+	ofi, err := fs.Lstat(other)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	if !ofi.Mode().IsRegular() || !fi.Mode().IsRegular() || ofi.Size() != fi.Size() {
+		return false, nil
+	}
+
+	sum, err := readDigest(fs, path, crypto.SHA256)
+	if err != nil {
+		return false, err
+	}
+	osum, err := readDigest(fs, other, crypto.SHA256)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sum, osum), nil
+}
+
+// WriteFileIfChanged is like WriteFile, but leaves the destination file
+// untouched (including its modification time) if it already has the given
+// content. It reports whether the file was replaced.
+func WriteFileIfChanged(filename string, data []byte, perm os.FileMode, opts ...Option) (bool, error) {
+	t, err := NewPendingFile(filename, append(writeFileOptions(perm, opts), WithSkipIfUnchanged())...)
+	if err != nil {
+		return false, err
+	}
+	defer t.Cleanup()
+
+	if _, err := t.Write(data); err != nil {
+		return false, err
+	}
+
+	if err := t.CloseAtomicallyReplace(); err != nil {
+		return false, err
+	}
+
+	return !t.Unchanged(), nil
+}
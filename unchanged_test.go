@@ -0,0 +1,90 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFileIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "generated.go")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	for _, tc := range []struct {
+		content     string
+		wantChanged bool
+	}{
+		{content: "package a", wantChanged: true},
+		{content: "package a", wantChanged: false},
+		{content: "package b", wantChanged: true},
+		{content: "package b\n", wantChanged: true},
+		{content: "", wantChanged: true},
+		{content: "", wantChanged: false},
+	} {
+		changed, err := WriteFileIfChanged(path, []byte(tc.content), 0o644)
+		if err != nil {
+			t.Fatalf("WriteFileIfChanged(%q, %q) failed: %v", path, tc.content, err)
+		}
+		if changed != tc.wantChanged {
+			t.Errorf("WriteFileIfChanged(%q, %q) = %v, want %v", path, tc.content, changed, tc.wantChanged)
+		}
+
+		checkContents(t, tc.content, path)
+		checkEntries(t, dir, 1)
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if touched := !fi.ModTime().Equal(past); touched != tc.wantChanged {
+			t.Errorf("modification time of %q changed: %v, want %v", path, touched, tc.wantChanged)
+		}
+
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSkipIfUnchangedPendingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+
+	for i, want := range []bool{false, true} {
+		pf, err := NewPendingFile(path, WithSkipIfUnchanged())
+		if err != nil {
+			t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+		}
+		defer pf.Cleanup()
+
+		if _, err := pf.WriteString("same"); err != nil {
+			t.Errorf("WriteString() failed: %v", err)
+		}
+
+		if err := pf.CloseAtomicallyReplace(); err != nil {
+			t.Errorf("CloseAtomicallyReplace() failed: %v", err)
+		}
+
+		if got := pf.Unchanged(); got != want {
+			t.Errorf("Unchanged() after commit %d = %v, want %v", i, got, want)
+		}
+	}
+}
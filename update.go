@@ -0,0 +1,143 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Default number of retries for Update
+const defaultUpdateRetries = 3
+
+// ErrUpdateConflict is returned (wrapped) by Update when the file kept being
+// modified concurrently and no attempt could be committed.
+var ErrUpdateConflict = errors.New("file modified concurrently")
+
+// Update atomically replaces the content of the file at path with the result of
+// calling fn with its current content. If path does not exist, fn is called
+// with a nil slice.
+//
+// Before committing, Update checks that the file has not been replaced or
+// modified since it was read by comparing its identity (device and inode,
+// modification time and size). If it has, fn is called again with the new
+// content, up to the number of times configured with WithUpdateRetries. Note
+// that the check and the rename are not a single atomic operation; a
+// concurrent writer may still slip in between. Only the creation of a file
+// which did not exist before is guaranteed to be exclusive (see
+// CloseAtomicallyCreate).
+//
+// Unless overridden by opts, the permissions of the existing file are kept and
+// the containing directory is synced.
+func Update(path string, fn func(old []byte) ([]byte, error), opts ...Option) error {
+	cfg := newConfig(path, append([]Option{WithUpdateRetries(defaultUpdateRetries)}, opts...))
+
+	opts = append([]Option{
+		WithExistingPermissions(),
+		WithSyncDir(),
+	}, opts...)
+
+	for attempt := 0; ; attempt++ {
+		err := update(cfg.fs, path, fn, opts)
+		if !errors.Is(err, ErrUpdateConflict) || attempt >= cfg.updateRetries {
+			return err
+		}
+	}
+}
+
+// update performs a single read-modify-write cycle for Update.
+func update(fs FS, path string, fn func(old []byte) ([]byte, error), opts []Option) error {
+	old, orig, err := readWithIdentity(fs, path)
+	if err != nil {
+		return err
+	}
+
+	data, err := fn(old)
+	if err != nil {
+		return err
+	}
+
+	t, err := NewPendingFile(path, opts...)
+	if err != nil {
+		return err
+	}
+	defer t.Cleanup()
+
+	if _, err := t.Write(data); err != nil {
+		return err
+	}
+
+	if orig == nil {
+		if err := t.CloseAtomicallyCreate(); errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s: %w", path, ErrUpdateConflict)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	t.beforeRename = func() error {
+		current, err := fs.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if current == nil || !sameIdentity(fs, orig, current) {
+			return fmt.Errorf("%s: %w", path, ErrUpdateConflict)
+		}
+		return nil
+	}
+
+	return t.CloseAtomicallyReplace()
+}
+
+// readWithIdentity reads the file at path and returns its content along with
+// the file information describing the version read. Both are nil if the file
+// does not exist.
+func readWithIdentity(fs FS, path string) ([]byte, os.FileInfo, error) {
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, fi, nil
+}
+
+// sameIdentity reports whether a and b describe the same, unmodified file.
+// Device and inode numbers are only compared on OSFS, for which os.SameFile
+// works.
+func sameIdentity(fs FS, a, b os.FileInfo) bool {
+	if isOSFS(fs) && !os.SameFile(a, b) {
+		return false
+	}
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
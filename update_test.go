@@ -0,0 +1,123 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+
+	for i, want := range []string{"x", "xx", "xxx"} {
+		if err := Update(path, func(old []byte) ([]byte, error) {
+			if i == 0 && old != nil {
+				t.Errorf("Update() passed %q for non-existing file, want nil", old)
+			}
+			return append(old, 'x'), nil
+		}); err != nil {
+			t.Errorf("Update(%q) failed: %v", path, err)
+		}
+
+		if got, err := ioutil.ReadFile(path); err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", path, err)
+		} else if string(got) != want {
+			t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, want)
+		}
+	}
+
+	wantErr := errors.New("transform failed")
+	if err := Update(path, func(old []byte) ([]byte, error) {
+		return nil, wantErr
+	}); !errors.Is(err, wantErr) {
+		t.Errorf("Update(%q) did not fail with %v: %v", path, wantErr, err)
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		exists    bool
+		retries   int
+		conflicts int
+		wantErr   error
+		want      string
+	}{
+		{
+			name:      "retry",
+			exists:    true,
+			retries:   1,
+			conflicts: 1,
+			want:      "concurrent 1+update",
+		},
+		{
+			name:      "retry create",
+			retries:   1,
+			conflicts: 1,
+			want:      "concurrent 1+update",
+		},
+		{
+			name:      "exhausted",
+			exists:    true,
+			retries:   2,
+			conflicts: 3,
+			wantErr:   ErrUpdateConflict,
+			want:      "concurrent 3",
+		},
+		{
+			name:      "exhausted create",
+			conflicts: 1,
+			wantErr:   ErrUpdateConflict,
+			want:      "concurrent 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+
+			if tc.exists {
+				if err := WriteFile(path, []byte("initial"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			calls := 0
+			err := Update(path, func(old []byte) ([]byte, error) {
+				calls++
+				if calls <= tc.conflicts {
+					// Simulate a concurrent writer replacing the file.
+					if err := WriteFile(path, []byte("concurrent "+string(rune('0'+calls))), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return append(old, "+update"...), nil
+			}, WithUpdateRetries(tc.retries))
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Update(%q) returned %v, want %v", path, err, tc.wantErr)
+			}
+
+			if got, err := ioutil.ReadFile(path); err != nil {
+				t.Errorf("ReadFile(%q) failed: %v", path, err)
+			} else if string(got) != tc.want {
+				t.Errorf("Read unexpected content %q from %q, want %q", string(got), path, tc.want)
+			}
+		})
+	}
+}
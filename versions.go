@@ -0,0 +1,219 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Suffix of compressed versions
+const gzipSuffix = ".gz"
+
+// Version is a previous version of a file kept by WithVersionRotation.
+type Version struct {
+	// N is the version number, 1 being the most recent.
+	N int
+
+	// Path is the file holding the version.
+	Path string
+
+	// Compressed is set for gzip-compressed versions.
+	Compressed bool
+}
+
+// versionPath returns the path of version n of the file at path.
+func versionPath(path string, n int, compressed bool) string {
+	name := path + "." + strconv.Itoa(n)
+	if compressed {
+		name += gzipSuffix
+	}
+	return name
+}
+
+// ListVersions returns the previous versions of the file at path kept by
+// WithVersionRotation, ordered from most recent to oldest.
+func ListVersions(path string) ([]Version, error) {
+	dir := filepath.Dir(path)
+	prefix := filepath.Base(path) + "."
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, fi := range entries {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		num := strings.TrimPrefix(name, prefix)
+		compressed := strings.HasSuffix(num, gzipSuffix)
+		num = strings.TrimSuffix(num, gzipSuffix)
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 || strconv.Itoa(n) != num {
+			continue
+		}
+		versions = append(versions, Version{
+			N:          n,
+			Path:       filepath.Join(dir, name),
+			Compressed: compressed,
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].N < versions[j].N
+	})
+
+	return versions, nil
+}
+
+// Restore atomically replaces the file at path with its previous version n
+// (see ListVersions), decompressing it if necessary. The version itself is
+// kept. Unless overridden by opts, the permissions of the existing file are
+// kept and the containing directory is synced.
+func Restore(path string, n int, opts ...Option) error {
+	versions, err := ListVersions(path)
+	if err != nil {
+		return err
+	}
+
+	var v *Version
+	for i := range versions {
+		if versions[i].N == n {
+			v = &versions[i]
+			break
+		}
+	}
+	if v == nil {
+		return &os.PathError{Op: "restore", Path: versionPath(path, n, false), Err: os.ErrNotExist}
+	}
+
+	f, err := os.Open(v.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = f
+	if v.Compressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	_, err = WriteFileFrom(path, r, fi.Mode()&os.ModePerm, opts...)
+	return err
+}
+
+// rotateVersions shifts the previous versions of the destination file by one,
+// dropping the oldest, and makes the destination file the most recent version,
+// if configured and the destination exists.
+func (t *PendingFile) rotateVersions() error {
+	if t.keepVersions <= 0 {
+		return nil
+	}
+
+	fi, err := t.fs.Lstat(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// A previous commit which was aborted after rotating already made the
+	// destination the most recent version.
+	if !t.compressVersions && isOSFS(t.fs) {
+		if latest, err := t.fs.Lstat(versionPath(t.path, 1, false)); err == nil && os.SameFile(fi, latest) {
+			return nil
+		}
+	}
+
+	for n := t.keepVersions - 1; n >= 1; n-- {
+		for _, compressed := range []bool{false, true} {
+			from := versionPath(t.path, n, compressed)
+			if err := t.fs.Rename(from, versionPath(t.path, n+1, compressed)); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			// Drop a stale version with the same number but different
+			// compression.
+			if err := t.fs.Remove(versionPath(t.path, n+1, !compressed)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	stale := versionPath(t.path, 1, !t.compressVersions)
+	if err := t.fs.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if !t.compressVersions {
+		return linkReplace(t.fs, t.random, t.path, versionPath(t.path, 1, false))
+	}
+	return compressVersion(t.fs, t.path, versionPath(t.path, 1, true))
+}
+
+// compressVersion atomically writes a gzip-compressed copy of the file at path
+// to dest.
+func compressVersion(fs FS, path, dest string) error {
+	f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	t, err := NewPendingFile(dest, WithFS(fs), WithTempDir(filepath.Dir(dest)), WithStaticPermissions(fi.Mode()&os.ModePerm))
+	if err != nil {
+		return err
+	}
+	defer t.Cleanup()
+
+	zw := gzip.NewWriter(t)
+	if _, err := io.Copy(zw, f); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return t.CloseAtomicallyReplace()
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package renameio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVersionRotation(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		name := "plain"
+		opts := []Option{WithVersionRotation(3)}
+		if compressed {
+			name = "compressed"
+			opts = append(opts, WithCompressedVersions())
+		}
+
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "app.conf")
+
+			for _, content := range []string{"v1", "v2", "v3", "v4", "v5"} {
+				if err := WriteFile(path, []byte(content), 0o644, opts...); err != nil {
+					t.Fatalf("WriteFile(%q) failed: %v", path, err)
+				}
+			}
+
+			versions, err := ListVersions(path)
+			if err != nil {
+				t.Fatalf("ListVersions(%q) failed: %v", path, err)
+			}
+
+			var want []Version
+			for n := 1; n <= 3; n++ {
+				want = append(want, Version{
+					N:          n,
+					Path:       versionPath(path, n, compressed),
+					Compressed: compressed,
+				})
+			}
+			if !reflect.DeepEqual(versions, want) {
+				t.Errorf("ListVersions(%q) = %+v, want %+v", path, versions, want)
+			}
+
+			checkEntries(t, dir, 4)
+
+			for n, content := range map[int]string{1: "v4", 3: "v2"} {
+				if err := Restore(path, n); err != nil {
+					t.Errorf("Restore(%q, %d) failed: %v", path, n, err)
+				}
+				checkContents(t, content, path)
+			}
+
+			if err := Restore(path, 4); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Restore(%q, 4) did not fail with ErrNotExist: %v", path, err)
+			}
+		})
+	}
+}
+
+func TestVersionRotationSwitchCompression(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.conf")
+
+	for _, opts := range [][]Option{
+		{WithVersionRotation(2)},
+		{WithVersionRotation(2)},
+		{WithVersionRotation(2), WithCompressedVersions()},
+		{WithVersionRotation(2), WithCompressedVersions()},
+	} {
+		if err := WriteFile(path, []byte("content"), 0o644, opts...); err != nil {
+			t.Fatalf("WriteFile(%q) failed: %v", path, err)
+		}
+	}
+
+	versions, err := ListVersions(path)
+	if err != nil {
+		t.Fatalf("ListVersions(%q) failed: %v", path, err)
+	}
+
+	want := []Version{
+		{N: 1, Path: versionPath(path, 1, true), Compressed: true},
+		{N: 2, Path: versionPath(path, 2, true), Compressed: true},
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("ListVersions(%q) = %+v, want %+v", path, versions, want)
+	}
+}
+
+func TestVersionRotationAborted(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	for _, tc := range []struct {
+		name string
+		// fail reports whether the nth call of beforeRename fails.
+		fail func(n int) bool
+	}{
+		{"before rotation", func(n int) bool { return true }},
+		{"after rotation", func(n int) bool { return n > 1 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "app.conf")
+
+			for _, content := range []string{"A", "B"} {
+				if err := WriteFile(path, []byte(content), 0o644, WithVersionRotation(3)); err != nil {
+					t.Fatalf("WriteFile(%q) failed: %v", path, err)
+				}
+			}
+
+			pf, err := NewPendingFile(path, WithVersionRotation(3))
+			if err != nil {
+				t.Fatalf("NewPendingFile(%q) failed: %v", path, err)
+			}
+			defer pf.Cleanup()
+			if _, err := pf.WriteString("C"); err != nil {
+				t.Fatal(err)
+			}
+			calls := 0
+			pf.beforeRename = func() error {
+				calls++
+				if tc.fail(calls) {
+					return errAbort
+				}
+				return nil
+			}
+			if err := pf.CloseAtomicallyReplace(); !errors.Is(err, errAbort) {
+				t.Fatalf("CloseAtomicallyReplace() = %v, want %v", err, errAbort)
+			}
+
+			if err := WriteFile(path, []byte("C"), 0o644, WithVersionRotation(3)); err != nil {
+				t.Fatalf("WriteFile(%q) failed: %v", path, err)
+			}
+
+			checkContents(t, "C", path)
+			checkContents(t, "B", versionPath(path, 1, false))
+			checkContents(t, "A", versionPath(path, 2, false))
+			if _, err := os.Lstat(versionPath(path, 3, false)); !os.IsNotExist(err) {
+				t.Errorf("Lstat(%q) didn't report that file doesn't exist: %v", versionPath(path, 3, false), err)
+			}
+		})
+	}
+}
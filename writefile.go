@@ -17,17 +17,26 @@
 
 package renameio
 
-import "os"
+import (
+	"io"
+	"os"
+)
 
-// WriteFile mirrors ioutil.WriteFile, replacing an existing file with the same
-// name atomically.
-func WriteFile(filename string, data []byte, perm os.FileMode, opts ...Option) error {
-	opts = append([]Option{
+// writeFileOptions prepends the defaults used by WriteFile and its variants to
+// opts.
+func writeFileOptions(perm os.FileMode, opts []Option) []Option {
+	return append([]Option{
 		WithPermissions(perm),
 		WithExistingPermissions(),
+		WithSyncDir(),
 	}, opts...)
+}
 
-	t, err := NewPendingFile(filename, opts...)
+// WriteFile mirrors ioutil.WriteFile, replacing an existing file with the same
+// name atomically. The containing directory is synced after the replacement
+// (see WithSyncDir).
+func WriteFile(filename string, data []byte, perm os.FileMode, opts ...Option) error {
+	t, err := NewPendingFile(filename, writeFileOptions(perm, opts)...)
 	if err != nil {
 		return err
 	}
@@ -39,3 +48,25 @@ func WriteFile(filename string, data []byte, perm os.FileMode, opts ...Option) e
 
 	return t.CloseAtomicallyReplace()
 }
+
+// WriteFileFrom is like WriteFile, but streams the file content from r instead
+// of taking it as a byte slice. The destination file is only replaced once r
+// returns io.EOF; if reading from r fails, the temporary file is removed and
+// the destination is left untouched. The number of bytes written is returned.
+//
+// If r is an *os.File (or an *io.LimitedReader wrapping one), the data may be
+// copied within the kernel, e.g. using copy_file_range(2) on Linux.
+func WriteFileFrom(filename string, r io.Reader, perm os.FileMode, opts ...Option) (int64, error) {
+	t, err := NewPendingFile(filename, writeFileOptions(perm, opts)...)
+	if err != nil {
+		return 0, err
+	}
+	defer t.Cleanup()
+
+	n, err := t.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+
+	return n, t.CloseAtomicallyReplace()
+}
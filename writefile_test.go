@@ -19,7 +19,9 @@ package renameio
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -180,3 +182,89 @@ func TestWriteFileEquivalence(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteFileFrom(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(t.TempDir(), "src")
+	filename := filepath.Join(dir, "dst")
+
+	wantData := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	if err := ioutil.WriteFile(src, wantData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		open func() (io.Reader, error)
+	}{
+		{
+			name: "bytes",
+			open: func() (io.Reader, error) {
+				return bytes.NewReader(wantData), nil
+			},
+		},
+		{
+			name: "file",
+			open: func() (io.Reader, error) {
+				return os.Open(src)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := tc.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := r.(io.Closer); ok {
+				defer c.Close()
+			}
+
+			n, err := WriteFileFrom(filename, r, 0o644)
+			if err != nil {
+				t.Fatalf("WriteFileFrom(%q) failed: %v", filename, err)
+			}
+			if want := int64(len(wantData)); n != want {
+				t.Errorf("WriteFileFrom(%q) wrote %d bytes, want %d", filename, n, want)
+			}
+
+			gotData, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(gotData, wantData) {
+				t.Errorf("got %d bytes of unexpected data", len(gotData))
+			}
+		})
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		wantErr := errors.New("connection reset")
+		r := io.MultiReader(bytes.NewReader([]byte("partial")), &errReader{wantErr})
+
+		if _, err := WriteFileFrom(filename, r, 0o644); !errors.Is(err, wantErr) {
+			t.Errorf("WriteFileFrom(%q) did not fail with %v: %v", filename, wantErr, err)
+		}
+
+		gotData, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(gotData, wantData) {
+			t.Errorf("destination was modified despite read error")
+		}
+
+		if entries, err := ioutil.ReadDir(dir); err != nil {
+			t.Errorf("ReadDir(%q) failed: %v", dir, err)
+		} else if len(entries) != 1 {
+			t.Errorf("Directory %q contains %d entries, want 1", dir, len(entries))
+		}
+	})
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
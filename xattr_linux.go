@@ -0,0 +1,114 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"bytes"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies all extended attributes of the file at path to f, skipping
+// those which cannot be set by the process.
+func copyXattrs(path string, f *os.File) error {
+	names, err := listXattrs(path)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err == unix.ENODATA {
+			continue // removed in the meantime
+		} else if err != nil {
+			return &os.PathError{Op: "lgetxattr", Path: path, Err: err}
+		}
+
+		switch err := unix.Fsetxattr(int(f.Fd()), name, value, 0); err {
+		case nil:
+		case unix.EPERM, unix.EACCES, unix.ENOTSUP:
+			// Not permitted in this namespace or not supported by the file
+			// system.
+		default:
+			return &os.PathError{Op: "fsetxattr", Path: f.Name(), Err: err}
+		}
+	}
+
+	return nil
+}
+
+// setXattr sets the extended attribute name on f.
+func setXattr(f *os.File, name string, value []byte) error {
+	if err := unix.Fsetxattr(int(f.Fd()), name, value, 0); err != nil {
+		return &os.PathError{Op: "fsetxattr", Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of the file at path
+// (not following symlinks).
+func listXattrs(path string) ([]string, error) {
+	var buf []byte
+	for {
+		sz, err := unix.Llistxattr(path, nil)
+		if err == unix.ENOTSUP {
+			return nil, nil
+		} else if err != nil {
+			return nil, &os.PathError{Op: "llistxattr", Path: path, Err: err}
+		}
+		if sz == 0 {
+			return nil, nil
+		}
+
+		buf = make([]byte, sz)
+		sz, err = unix.Llistxattr(path, buf)
+		if err == unix.ERANGE {
+			continue // attributes were added in the meantime
+		} else if err != nil {
+			return nil, &os.PathError{Op: "llistxattr", Path: path, Err: err}
+		}
+		buf = buf[:sz]
+		break
+	}
+
+	var names []string
+	for _, name := range bytes.Split(buf, []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of the extended attribute name of the file at
+// path (not following symlinks).
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		sz, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, sz)
+		sz, err = unix.Lgetxattr(path, name, value)
+		if err == unix.ERANGE {
+			continue // value grew in the meantime
+		} else if err != nil {
+			return nil, err
+		}
+		return value[:sz], nil
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renameio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labelled")
+	if err := ioutil.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unix.Setxattr(path, "user.origin", []byte("old"), 0); err == unix.ENOTSUP {
+		t.Skipf("user xattrs not supported: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(path, "user.keep", []byte("kept"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("new"), 0o644,
+		WithExistingXattrs(),
+		WithXattr("user.origin", []byte("new")),
+		WithXattr("user.added", nil),
+	); err != nil {
+		t.Fatalf("WriteFile(%q) failed: %v", path, err)
+	}
+
+	names, err := listXattrs(path)
+	if err != nil {
+		t.Fatalf("listXattrs(%q) failed: %v", path, err)
+	}
+
+	got := map[string]string{}
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err != nil {
+			t.Fatalf("getXattr(%q, %q) failed: %v", path, name, err)
+		}
+		got[name] = string(value)
+	}
+
+	for name, want := range map[string]string{
+		"user.origin": "new",
+		"user.keep":   "kept",
+		"user.added":  "",
+	} {
+		if value, ok := got[name]; !ok {
+			t.Errorf("xattr %q missing on %q", name, path)
+		} else if value != want {
+			t.Errorf("xattr %q is %q, want %q", name, value, want)
+		}
+	}
+}
+
+func TestXattrsNotPreservedByDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labelled")
+	if err := ioutil.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unix.Setxattr(path, "user.origin", []byte("old"), 0); err == unix.ENOTSUP {
+		t.Skipf("user xattrs not supported: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("new"), 0o644); err != nil {
+		t.Fatalf("WriteFile(%q) failed: %v", path, err)
+	}
+
+	if _, err := getXattr(path, "user.origin"); err != unix.ENODATA {
+		t.Errorf("getXattr(%q) did not fail with ENODATA: %v", path, err)
+	}
+}
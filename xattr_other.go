@@ -0,0 +1,35 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package renameio
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyXattrs is a no-op as extended attributes are not supported on this
+// platform.
+func copyXattrs(path string, f *os.File) error {
+	return nil
+}
+
+// setXattr always fails with ENOTSUP as extended attributes are not supported
+// on this platform.
+func setXattr(f *os.File, name string, value []byte) error {
+	return &os.PathError{Op: "setxattr", Path: f.Name(), Err: syscall.ENOTSUP}
+}